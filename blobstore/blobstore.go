@@ -0,0 +1,66 @@
+// Package blobstore provides the storage of the message payloads exceeding the maximum message size allowed by AWS
+// (claim-check pattern). Publishers configured with a BlobStore store those payloads and publish a pointer message
+// instead, subscribers configured with the same BlobStore fetch the payload back when receiving the pointer message.
+//
+// S3 and local filesystem stores are provided by the s3 and filesystem packages:
+//
+//	store := s3.New(s3.Config{Bucket: "payloads"})
+//	pub := sqs.New(sqs.Config{QueueURL: queueURL, BlobStore: store})
+//	subs := subscriber.New(subscriber.Config{SqsQueueURL: queueURL, BlobStore: store})
+package blobstore
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+const (
+	// PayloadSizeAttribute is the message attribute holding the size of the offloaded payload.
+	// It marks the messages whose body is a Pointer. Pointers are not compatible with the ones of the
+	// Amazon SQS Extended Client Library, so its ExtendedPayloadSize attribute name is not reused
+	// and the messages it publishes are received as they are
+	PayloadSizeAttribute = "x-payload-size"
+)
+
+// ErrNotFound is returned by Get when there is no blob stored with the given key
+var ErrNotFound = errors.New("blob not found")
+
+// BlobStore stores the offloaded message payloads
+type BlobStore interface {
+
+	// Put stores data with the given key
+	Put(ctx context.Context, key string, data []byte) error
+
+	// Get returns the data stored with the given key. Returns an error wrapping ErrNotFound if there is none
+	Get(ctx context.Context, key string) ([]byte, error)
+
+	// Delete deletes the data stored with the given key. Deleting a missing key is not an error
+	Delete(ctx context.Context, key string) error
+}
+
+// Pointer is the body of the messages whose payload is offloaded to a BlobStore
+type Pointer struct {
+
+	// Key of the payload in the BlobStore
+	Key string `json:"key"`
+}
+
+// Marshal returns the message body holding the pointer
+func (p Pointer) Marshal() string {
+	b, _ := json.Marshal(p)
+	return string(b)
+}
+
+// ParsePointer parses the body of a message whose payload is offloaded to a BlobStore
+func ParsePointer(body string) (Pointer, error) {
+	var p Pointer
+	if err := json.Unmarshal([]byte(body), &p); err != nil {
+		return p, fmt.Errorf("invalid payload pointer: %w", err)
+	}
+	if p.Key == "" {
+		return p, errors.New("invalid payload pointer: missing key")
+	}
+	return p, nil
+}
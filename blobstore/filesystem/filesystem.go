@@ -0,0 +1,83 @@
+// Package filesystem provides a blobstore.BlobStore storing the offloaded payloads as files of a local directory,
+// meant for development and tests or for publishers and subscribers sharing a filesystem
+package filesystem
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/bernardopericacho/htsqs/blobstore"
+)
+
+// Store stores every payload in a file of its directory named after the payload key
+type Store struct {
+	dir string
+}
+
+// New creates a store writing the payloads to the given directory, which must exist
+func New(dir string) *Store {
+	return &Store{dir: dir}
+}
+
+// Put writes the data to the file of the key. The file is written to a temporary file first,
+// so readers never see a partially written payload
+func (s *Store) Put(ctx context.Context, key string, data []byte) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+
+	f, err := ioutil.TempFile(s.dir, ".tmp-")
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+// Get reads the file of the key
+func (s *Store) Get(ctx context.Context, key string) ([]byte, error) {
+	path, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("%w: %s", blobstore.ErrNotFound, key)
+	}
+	return data, err
+}
+
+// Delete removes the file of the key
+func (s *Store) Delete(ctx context.Context, key string) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// path returns the path of the file of the key. Keys can not point outside the store directory
+func (s *Store) path(key string) (string, error) {
+	if key == "" || key == "." || key == ".." || strings.ContainsAny(key, `/\`) {
+		return "", fmt.Errorf("invalid key %q", key)
+	}
+	return filepath.Join(s.dir, key), nil
+}
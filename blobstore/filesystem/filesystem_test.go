@@ -0,0 +1,37 @@
+package filesystem
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/bernardopericacho/htsqs/blobstore"
+)
+
+func TestStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "htsqs")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	store := New(dir)
+
+	require.NoError(t, store.Put(context.TODO(), "key", []byte("payload")))
+	data, err := store.Get(context.TODO(), "key")
+	require.NoError(t, err)
+	require.Equal(t, "payload", string(data))
+
+	require.NoError(t, store.Delete(context.TODO(), "key"))
+	_, err = store.Get(context.TODO(), "key")
+	require.True(t, errors.Is(err, blobstore.ErrNotFound))
+	require.NoError(t, store.Delete(context.TODO(), "key"))
+
+	// Keys can not point outside the directory
+	require.EqualError(t, store.Put(context.TODO(), "../key", []byte("payload")), `invalid key "../key"`)
+
+	files, err := ioutil.ReadDir(dir)
+	require.NoError(t, err)
+	require.Empty(t, files)
+}
@@ -0,0 +1,97 @@
+// Package s3 provides a blobstore.BlobStore storing the offloaded payloads as objects of an AWS S3 bucket
+package s3
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+
+	"github.com/bernardopericacho/htsqs/blobstore"
+)
+
+// S3Client is the subset of s3iface.S3API used by the store
+type S3Client interface {
+	PutObjectWithContext(ctx aws.Context, input *s3.PutObjectInput, opts ...request.Option) (*s3.PutObjectOutput, error)
+	GetObjectWithContext(ctx aws.Context, input *s3.GetObjectInput, opts ...request.Option) (*s3.GetObjectOutput, error)
+	DeleteObjectWithContext(ctx aws.Context, input *s3.DeleteObjectInput, opts ...request.Option) (*s3.DeleteObjectOutput, error)
+}
+
+// Config holds the info required to store the payloads in AWS S3
+type Config struct {
+
+	// AWS session. Not used when S3Client is set
+	AWSSession *session.Session
+
+	// Client used to store the payloads. Built from AWSSession by default
+	S3Client S3Client
+
+	// Bucket the payloads are stored in
+	Bucket string
+
+	// Prefix added to the payload keys to build the object keys, e.g. "payloads/"
+	Prefix string
+}
+
+// Store stores every payload in an object of the bucket
+type Store struct {
+	s3  S3Client
+	cfg Config
+}
+
+// Put uploads the data to the object of the key
+func (s *Store) Put(ctx context.Context, key string, data []byte) error {
+	_, err := s.s3.PutObjectWithContext(ctx, &s3.PutObjectInput{
+		Bucket: &s.cfg.Bucket,
+		Key:    aws.String(s.cfg.Prefix + key),
+		Body:   bytes.NewReader(data),
+	})
+	return err
+}
+
+// Get downloads the object of the key
+func (s *Store) Get(ctx context.Context, key string) ([]byte, error) {
+	output, err := s.s3.GetObjectWithContext(ctx, &s3.GetObjectInput{
+		Bucket: &s.cfg.Bucket,
+		Key:    aws.String(s.cfg.Prefix + key),
+	})
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == s3.ErrCodeNoSuchKey {
+		return nil, fmt.Errorf("%w: %s", blobstore.ErrNotFound, key)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer output.Body.Close()
+	return ioutil.ReadAll(output.Body)
+}
+
+// Delete deletes the object of the key
+func (s *Store) Delete(ctx context.Context, key string) error {
+	_, err := s.s3.DeleteObjectWithContext(ctx, &s3.DeleteObjectInput{
+		Bucket: &s.cfg.Bucket,
+		Key:    aws.String(s.cfg.Prefix + key),
+	})
+	return err
+}
+
+func defaultStoreConfig(cfg *Config) {
+	if cfg.AWSSession == nil && cfg.S3Client == nil {
+		cfg.AWSSession = session.Must(session.NewSession())
+	}
+}
+
+// New creates a new AWS S3 store
+func New(cfg Config) *Store {
+	defaultStoreConfig(&cfg)
+	client := cfg.S3Client
+	if client == nil {
+		client = s3.New(cfg.AWSSession)
+	}
+	return &Store{cfg: cfg, s3: client}
+}
@@ -0,0 +1,60 @@
+package s3
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/stretchr/testify/require"
+
+	"github.com/bernardopericacho/htsqs/blobstore"
+)
+
+type s3Mock struct {
+	objects map[string][]byte
+}
+
+func (s *s3Mock) PutObjectWithContext(ctx aws.Context, input *s3.PutObjectInput, opts ...request.Option) (*s3.PutObjectOutput, error) {
+	data, err := ioutil.ReadAll(input.Body)
+	if err != nil {
+		return nil, err
+	}
+	s.objects[aws.StringValue(input.Bucket)+"/"+aws.StringValue(input.Key)] = data
+	return &s3.PutObjectOutput{}, nil
+}
+
+func (s *s3Mock) GetObjectWithContext(ctx aws.Context, input *s3.GetObjectInput, opts ...request.Option) (*s3.GetObjectOutput, error) {
+	data, ok := s.objects[aws.StringValue(input.Bucket)+"/"+aws.StringValue(input.Key)]
+	if !ok {
+		return nil, awserr.New(s3.ErrCodeNoSuchKey, "The specified key does not exist.", nil)
+	}
+	return &s3.GetObjectOutput{Body: ioutil.NopCloser(bytes.NewReader(data))}, nil
+}
+
+func (s *s3Mock) DeleteObjectWithContext(ctx aws.Context, input *s3.DeleteObjectInput, opts ...request.Option) (*s3.DeleteObjectOutput, error) {
+	delete(s.objects, aws.StringValue(input.Bucket)+"/"+aws.StringValue(input.Key))
+	return &s3.DeleteObjectOutput{}, nil
+}
+
+func TestStore(t *testing.T) {
+	mock := &s3Mock{objects: make(map[string][]byte)}
+	store := New(Config{S3Client: mock, Bucket: "bucket", Prefix: "payloads/"})
+
+	require.NoError(t, store.Put(context.TODO(), "key", []byte("payload")))
+	require.Equal(t, map[string][]byte{"bucket/payloads/key": []byte("payload")}, mock.objects)
+
+	data, err := store.Get(context.TODO(), "key")
+	require.NoError(t, err)
+	require.Equal(t, "payload", string(data))
+
+	require.NoError(t, store.Delete(context.TODO(), "key"))
+	require.Empty(t, mock.objects)
+	_, err = store.Get(context.TODO(), "key")
+	require.True(t, errors.Is(err, blobstore.ErrNotFound))
+}
@@ -0,0 +1,153 @@
+// Package codec provides the registry of codecs shared by publishers and subscribers to encode and decode
+// message bodies. The codec used to encode a message is identified by the content-type message attribute,
+// so subscribers can decode it with the same codec.
+//
+// JSON and raw bytes codecs are always registered. Protobuf and MessagePack codecs are registered
+// by importing their packages:
+//
+//	import _ "github.com/bernardopericacho/htsqs/codec/protobuf"
+package codec
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+const (
+	// ContentTypeAttribute is the message attribute holding the content type of the message body.
+	// Messages without it are JSON encoded
+	ContentTypeAttribute = "content-type"
+)
+
+// ErrUnsupportedType is returned when a codec can not encode or decode a value of the given type
+var ErrUnsupportedType = errors.New("type not supported by the codec")
+
+// Codec encodes and decodes message bodies
+type Codec interface {
+
+	// ContentType returns the MIME type identifying the codec
+	ContentType() string
+
+	// Marshal returns the encoding of v
+	Marshal(v interface{}) ([]byte, error)
+
+	// Unmarshal parses the encoded data and stores the result in the value pointed to by v
+	Unmarshal(data []byte, v interface{}) error
+
+	// Binary reports whether the encoded data is binary. Binary data is base64 encoded
+	// in the message body, since AWS only allows text in message bodies
+	Binary() bool
+}
+
+var (
+	mu     sync.RWMutex
+	codecs = make(map[string]Codec)
+)
+
+func init() {
+	Register(JSON)
+	Register(Raw)
+}
+
+// Register makes the codec available for its content type, replacing any codec previously registered for it
+func Register(c Codec) {
+	mu.Lock()
+	defer mu.Unlock()
+	codecs[c.ContentType()] = c
+}
+
+// Lookup returns the codec registered for the given content type
+func Lookup(contentType string) (Codec, error) {
+	mu.RLock()
+	defer mu.RUnlock()
+	c, ok := codecs[contentType]
+	if !ok {
+		return nil, fmt.Errorf("no codec registered for content type %q", contentType)
+	}
+	return c, nil
+}
+
+// JSON is the codec encoding values with encoding/json
+var JSON Codec = jsonCodec{}
+
+type jsonCodec struct{}
+
+func (jsonCodec) ContentType() string {
+	return "application/json"
+}
+
+func (jsonCodec) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (jsonCodec) Unmarshal(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
+
+func (jsonCodec) Binary() bool {
+	return false
+}
+
+// Raw is the codec sending []byte and string values as they are.
+// Values are decoded into *[]byte or *string values
+var Raw Codec = rawCodec{}
+
+type rawCodec struct{}
+
+func (rawCodec) ContentType() string {
+	return "application/octet-stream"
+}
+
+func (rawCodec) Marshal(v interface{}) ([]byte, error) {
+	switch value := v.(type) {
+	case []byte:
+		return value, nil
+	case string:
+		return []byte(value), nil
+	default:
+		return nil, ErrUnsupportedType
+	}
+}
+
+func (rawCodec) Unmarshal(data []byte, v interface{}) error {
+	switch value := v.(type) {
+	case *[]byte:
+		*value = append([]byte(nil), data...)
+	case *string:
+		*value = string(data)
+	default:
+		return ErrUnsupportedType
+	}
+	return nil
+}
+
+func (rawCodec) Binary() bool {
+	return true
+}
+
+// Verbatim returns a codec sending already encoded []byte and string values as they are, with the given content type.
+// It is not binary, as the values of binary content types are expected to be base64 encoded already, e.g. when
+// forwarding the body of a received message. Values are decoded into *[]byte or *string values
+func Verbatim(contentType string) Codec {
+	return verbatimCodec(contentType)
+}
+
+type verbatimCodec string
+
+func (c verbatimCodec) ContentType() string {
+	return string(c)
+}
+
+func (verbatimCodec) Marshal(v interface{}) ([]byte, error) {
+	return Raw.Marshal(v)
+}
+
+func (verbatimCodec) Unmarshal(data []byte, v interface{}) error {
+	return Raw.Unmarshal(data, v)
+}
+
+func (verbatimCodec) Binary() bool {
+	return false
+}
@@ -0,0 +1,73 @@
+package codec
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+type message struct {
+	Msg string `json:"msg"`
+}
+
+func TestJSON(t *testing.T) {
+	b, err := JSON.Marshal(message{Msg: "message"})
+	require.NoError(t, err)
+	require.Equal(t, `{"msg":"message"}`, string(b))
+
+	var m message
+	require.NoError(t, JSON.Unmarshal(b, &m))
+	require.Equal(t, message{Msg: "message"}, m)
+	require.False(t, JSON.Binary())
+}
+
+func TestRaw(t *testing.T) {
+	b, err := Raw.Marshal([]byte{0, 1, 2})
+	require.NoError(t, err)
+	require.Equal(t, []byte{0, 1, 2}, b)
+
+	b, err = Raw.Marshal("message")
+	require.NoError(t, err)
+	require.Equal(t, []byte("message"), b)
+
+	_, err = Raw.Marshal(message{})
+	require.Equal(t, ErrUnsupportedType, err)
+
+	var bytes []byte
+	require.NoError(t, Raw.Unmarshal([]byte{0, 1, 2}, &bytes))
+	require.Equal(t, []byte{0, 1, 2}, bytes)
+
+	var s string
+	require.NoError(t, Raw.Unmarshal([]byte("message"), &s))
+	require.Equal(t, "message", s)
+
+	require.Equal(t, ErrUnsupportedType, Raw.Unmarshal([]byte("message"), &message{}))
+	require.True(t, Raw.Binary())
+}
+
+func TestVerbatim(t *testing.T) {
+	c := Verbatim("application/x-protobuf")
+	require.Equal(t, "application/x-protobuf", c.ContentType())
+
+	b, err := c.Marshal("CgdtZXNzYWdl")
+	require.NoError(t, err)
+	require.Equal(t, []byte("CgdtZXNzYWdl"), b)
+
+	var s string
+	require.NoError(t, c.Unmarshal(b, &s))
+	require.Equal(t, "CgdtZXNzYWdl", s)
+	require.False(t, c.Binary())
+}
+
+func TestLookup(t *testing.T) {
+	c, err := Lookup("application/json")
+	require.NoError(t, err)
+	require.Equal(t, JSON, c)
+
+	c, err = Lookup("application/octet-stream")
+	require.NoError(t, err)
+	require.Equal(t, Raw, c)
+
+	_, err = Lookup("application/unknown")
+	require.EqualError(t, err, `no codec registered for content type "application/unknown"`)
+}
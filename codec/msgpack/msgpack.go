@@ -0,0 +1,36 @@
+// Package msgpack provides the MessagePack codec. Importing it registers the codec for its content type.
+package msgpack
+
+import (
+	"github.com/vmihailenco/msgpack/v5"
+
+	"github.com/bernardopericacho/htsqs/codec"
+)
+
+// ContentType is the content type of the messages encoded with MessagePack
+const ContentType = "application/x-msgpack"
+
+// Codec is the codec encoding values in the MessagePack format
+var Codec codec.Codec = msgpackCodec{}
+
+func init() {
+	codec.Register(Codec)
+}
+
+type msgpackCodec struct{}
+
+func (msgpackCodec) ContentType() string {
+	return ContentType
+}
+
+func (msgpackCodec) Marshal(v interface{}) ([]byte, error) {
+	return msgpack.Marshal(v)
+}
+
+func (msgpackCodec) Unmarshal(data []byte, v interface{}) error {
+	return msgpack.Unmarshal(data, v)
+}
+
+func (msgpackCodec) Binary() bool {
+	return true
+}
@@ -0,0 +1,28 @@
+package msgpack
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/bernardopericacho/htsqs/codec"
+)
+
+type message struct {
+	Msg   string
+	Count int
+}
+
+func TestCodec(t *testing.T) {
+	b, err := Codec.Marshal(message{Msg: "message", Count: 3})
+	require.NoError(t, err)
+
+	var m message
+	require.NoError(t, Codec.Unmarshal(b, &m))
+	require.Equal(t, message{Msg: "message", Count: 3}, m)
+
+	registered, err := codec.Lookup(ContentType)
+	require.NoError(t, err)
+	require.Equal(t, Codec, registered)
+	require.True(t, Codec.Binary())
+}
@@ -0,0 +1,44 @@
+// Package protobuf provides the Protocol Buffers codec. Importing it registers the codec for its content type.
+package protobuf
+
+import (
+	"google.golang.org/protobuf/proto"
+
+	"github.com/bernardopericacho/htsqs/codec"
+)
+
+// ContentType is the content type of the messages encoded with Protocol Buffers
+const ContentType = "application/x-protobuf"
+
+// Codec is the codec encoding proto.Message values in the Protocol Buffers wire format
+var Codec codec.Codec = protobufCodec{}
+
+func init() {
+	codec.Register(Codec)
+}
+
+type protobufCodec struct{}
+
+func (protobufCodec) ContentType() string {
+	return ContentType
+}
+
+func (protobufCodec) Marshal(v interface{}) ([]byte, error) {
+	m, ok := v.(proto.Message)
+	if !ok {
+		return nil, codec.ErrUnsupportedType
+	}
+	return proto.Marshal(m)
+}
+
+func (protobufCodec) Unmarshal(data []byte, v interface{}) error {
+	m, ok := v.(proto.Message)
+	if !ok {
+		return codec.ErrUnsupportedType
+	}
+	return proto.Unmarshal(data, m)
+}
+
+func (protobufCodec) Binary() bool {
+	return true
+}
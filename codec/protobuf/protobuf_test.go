@@ -0,0 +1,29 @@
+package protobuf
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+
+	"github.com/bernardopericacho/htsqs/codec"
+)
+
+func TestCodec(t *testing.T) {
+	b, err := Codec.Marshal(wrapperspb.String("message"))
+	require.NoError(t, err)
+
+	m := new(wrapperspb.StringValue)
+	require.NoError(t, Codec.Unmarshal(b, m))
+	require.True(t, proto.Equal(wrapperspb.String("message"), m))
+
+	_, err = Codec.Marshal("message")
+	require.Equal(t, codec.ErrUnsupportedType, err)
+	require.Equal(t, codec.ErrUnsupportedType, Codec.Unmarshal(b, new(string)))
+
+	registered, err := codec.Lookup(ContentType)
+	require.NoError(t, err)
+	require.Equal(t, Codec, registered)
+	require.True(t, Codec.Binary())
+}
@@ -0,0 +1,110 @@
+// Package compression provides the registry of compressors shared by publishers and subscribers to compress and
+// decompress message bodies. The compressor used to compress a message is identified by the content-encoding
+// message attribute, so subscribers can decompress it before handing it to the handlers.
+//
+// Gzip is always registered. Zstandard is registered by importing its package:
+//
+//	import _ "github.com/bernardopericacho/htsqs/compression/zstd"
+package compression
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sync"
+)
+
+const (
+	// ContentEncodingAttribute is the message attribute holding the encoding of the compressed message bodies.
+	// Messages without it are not compressed
+	ContentEncodingAttribute = "content-encoding"
+
+	// MaxDecompressedSize is the maximum size, in bytes, of decompressed data. It keeps small messages from
+	// expanding to bodies that exhaust the memory of the subscribers
+	MaxDecompressedSize = 64 << 20
+)
+
+// ErrTooLarge is returned by Decompress when the decompressed data exceeds MaxDecompressedSize
+var ErrTooLarge = errors.New("decompressed data exceeds the maximum size")
+
+// Compressor compresses and decompresses message bodies
+type Compressor interface {
+
+	// Encoding returns the name identifying the compressor, e.g. gzip
+	Encoding() string
+
+	// Compress returns the compressed data
+	Compress(data []byte) ([]byte, error)
+
+	// Decompress returns the decompressed data. Returns ErrTooLarge when it exceeds MaxDecompressedSize
+	Decompress(data []byte) ([]byte, error)
+}
+
+var (
+	mu          sync.RWMutex
+	compressors = make(map[string]Compressor)
+)
+
+func init() {
+	Register(Gzip)
+}
+
+// Register makes the compressor available for its encoding, replacing any compressor previously registered for it
+func Register(c Compressor) {
+	mu.Lock()
+	defer mu.Unlock()
+	compressors[c.Encoding()] = c
+}
+
+// Lookup returns the compressor registered for the given encoding
+func Lookup(encoding string) (Compressor, error) {
+	mu.RLock()
+	defer mu.RUnlock()
+	c, ok := compressors[encoding]
+	if !ok {
+		return nil, fmt.Errorf("no compressor registered for content encoding %q", encoding)
+	}
+	return c, nil
+}
+
+// Gzip is the compressor using compress/gzip with the default compression level
+var Gzip Compressor = gzipCompressor{}
+
+type gzipCompressor struct{}
+
+func (gzipCompressor) Encoding() string {
+	return "gzip"
+}
+
+func (gzipCompressor) Compress(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (gzipCompressor) Decompress(data []byte) ([]byte, error) {
+	r, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	// Read one byte past the limit to tell data of the maximum size from larger data
+	decompressed, err := ioutil.ReadAll(io.LimitReader(r, MaxDecompressedSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(decompressed) > MaxDecompressedSize {
+		return nil, ErrTooLarge
+	}
+	return decompressed, nil
+}
@@ -0,0 +1,45 @@
+package compression
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestGzip(t *testing.T) {
+	data := []byte(strings.Repeat("message", 100))
+	compressed, err := Gzip.Compress(data)
+	require.NoError(t, err)
+	require.Less(t, len(compressed), len(data))
+
+	decompressed, err := Gzip.Decompress(compressed)
+	require.NoError(t, err)
+	require.Equal(t, data, decompressed)
+
+	_, err = Gzip.Decompress(data)
+	require.Error(t, err)
+}
+
+func TestGzipTooLarge(t *testing.T) {
+	compressed, err := Gzip.Compress(make([]byte, MaxDecompressedSize+1))
+	require.NoError(t, err)
+	_, err = Gzip.Decompress(compressed)
+	require.Equal(t, ErrTooLarge, err)
+
+	// Data of the maximum size is decompressed
+	compressed, err = Gzip.Compress(make([]byte, MaxDecompressedSize))
+	require.NoError(t, err)
+	decompressed, err := Gzip.Decompress(compressed)
+	require.NoError(t, err)
+	require.Len(t, decompressed, MaxDecompressedSize)
+}
+
+func TestLookup(t *testing.T) {
+	c, err := Lookup("gzip")
+	require.NoError(t, err)
+	require.Equal(t, Gzip, c)
+
+	_, err = Lookup("br")
+	require.EqualError(t, err, `no compressor registered for content encoding "br"`)
+}
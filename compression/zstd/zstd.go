@@ -0,0 +1,45 @@
+// Package zstd provides the Zstandard compressor. Importing it registers the compressor for its encoding.
+package zstd
+
+import (
+	"errors"
+
+	"github.com/klauspost/compress/zstd"
+
+	"github.com/bernardopericacho/htsqs/compression"
+)
+
+// Encoding is the content encoding of the messages compressed with Zstandard
+const Encoding = "zstd"
+
+// Compressor is the compressor using Zstandard with the default compression level
+var Compressor compression.Compressor = zstdCompressor{}
+
+var (
+	// encoder and decoder are safe for concurrent use through EncodeAll and DecodeAll.
+	// The decoder does not decompress data larger than compression.MaxDecompressedSize
+	encoder, _ = zstd.NewWriter(nil)
+	decoder, _ = zstd.NewReader(nil, zstd.WithDecoderMaxMemory(compression.MaxDecompressedSize))
+)
+
+func init() {
+	compression.Register(Compressor)
+}
+
+type zstdCompressor struct{}
+
+func (zstdCompressor) Encoding() string {
+	return Encoding
+}
+
+func (zstdCompressor) Compress(data []byte) ([]byte, error) {
+	return encoder.EncodeAll(data, nil), nil
+}
+
+func (zstdCompressor) Decompress(data []byte) ([]byte, error) {
+	decompressed, err := decoder.DecodeAll(data, nil)
+	if errors.Is(err, zstd.ErrDecoderSizeExceeded) {
+		return nil, compression.ErrTooLarge
+	}
+	return decompressed, err
+}
@@ -0,0 +1,32 @@
+package zstd
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/bernardopericacho/htsqs/compression"
+)
+
+func TestCompressor(t *testing.T) {
+	data := []byte(strings.Repeat("message", 100))
+	compressed, err := Compressor.Compress(data)
+	require.NoError(t, err)
+	require.Less(t, len(compressed), len(data))
+
+	decompressed, err := Compressor.Decompress(compressed)
+	require.NoError(t, err)
+	require.Equal(t, data, decompressed)
+
+	registered, err := compression.Lookup(Encoding)
+	require.NoError(t, err)
+	require.Equal(t, Compressor, registered)
+}
+
+func TestCompressorTooLarge(t *testing.T) {
+	compressed, err := Compressor.Compress(make([]byte, compression.MaxDecompressedSize+1))
+	require.NoError(t, err)
+	_, err = Compressor.Decompress(compressed)
+	require.Equal(t, compression.ErrTooLarge, err)
+}
@@ -0,0 +1,154 @@
+// Package encryption provides the client-side envelope encryption of message bodies. Every message body is encrypted
+// with AES-GCM under a new data key generated by a KeyProvider, and the data key, encrypted under a master key,
+// is sent along with the message in its attributes. Subscribers configured with a KeyProvider holding the master key
+// decrypt the data key and then the body.
+//
+// Master keys can be rotated: every message carries the ID of the master key its data key was encrypted under,
+// so messages published before the rotation are decrypted as long as the KeyProvider holds the previous key.
+//
+// A StaticKeyProvider is provided for tests and development, and a provider backed by AWS KMS by the kms package.
+package encryption
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+)
+
+const (
+	// EncryptedKeyAttribute is the Binary message attribute holding the data key encrypted under the master key
+	EncryptedKeyAttribute = "x-encrypted-key"
+
+	// KeyIDAttribute is the message attribute holding the ID of the master key the data key was encrypted under.
+	// It marks the messages whose body is encrypted
+	KeyIDAttribute = "x-key-id"
+)
+
+// ErrUnknownKey is returned by the key providers when they do not hold the master key with the given ID
+var ErrUnknownKey = errors.New("unknown master key")
+
+// DataKey is a key used to encrypt a single message body
+type DataKey struct {
+
+	// Plaintext key. Must be 16, 24 or 32 bytes long, selecting AES-128, AES-192 or AES-256
+	Plaintext []byte
+
+	// Key encrypted under the master key
+	Encrypted []byte
+
+	// ID of the master key the key is encrypted under
+	KeyID string
+}
+
+// KeyProvider generates the data keys and decrypts them with the master keys it holds
+type KeyProvider interface {
+
+	// GenerateDataKey returns a new data key encrypted under the current master key
+	GenerateDataKey(ctx context.Context) (*DataKey, error)
+
+	// DecryptDataKey returns the plaintext of the data key encrypted under the master key with the given ID
+	DecryptDataKey(ctx context.Context, keyID string, encrypted []byte) ([]byte, error)
+}
+
+// Encrypt encrypts data under a new data key generated by the provider. The nonce is prepended to the ciphertext
+func Encrypt(ctx context.Context, p KeyProvider, data []byte) ([]byte, *DataKey, error) {
+	key, err := p.GenerateDataKey(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	ciphertext, err := seal(key.Plaintext, data)
+	if err != nil {
+		return nil, nil, err
+	}
+	return ciphertext, key, nil
+}
+
+// Decrypt decrypts data encrypted under the given data key, decrypting the data key first with the provider
+func Decrypt(ctx context.Context, p KeyProvider, keyID string, encryptedKey, data []byte) ([]byte, error) {
+	key, err := p.DecryptDataKey(ctx, keyID, encryptedKey)
+	if err != nil {
+		return nil, err
+	}
+	return open(key, data)
+}
+
+// seal encrypts data with AES-GCM under the given key and a random nonce, prepended to the ciphertext
+func seal(key, data []byte) ([]byte, error) {
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(data)+aead.Overhead())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, data, nil), nil
+}
+
+// open decrypts data encrypted by seal
+func open(key, data []byte) ([]byte, error) {
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < aead.NonceSize() {
+		return nil, errors.New("ciphertext too short")
+	}
+	return aead.Open(nil, data[:aead.NonceSize()], data[aead.NonceSize():], nil)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// StaticKeyProvider generates random AES-256 data keys and encrypts them with AES-GCM under master keys held
+// in memory. Meant for tests and development, master keys should be kept in a key management service
+type StaticKeyProvider struct {
+	current string
+	keys    map[string][]byte
+}
+
+// NewStaticKeyProvider creates a provider encrypting the data keys under the master key with the current ID.
+// The other keys are only used to decrypt the data keys encrypted under them, e.g. before a key rotation.
+// Master keys must be 16, 24 or 32 bytes long
+func NewStaticKeyProvider(current string, keys map[string][]byte) (*StaticKeyProvider, error) {
+	if _, ok := keys[current]; !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownKey, current)
+	}
+	for id, key := range keys {
+		if _, err := aes.NewCipher(key); err != nil {
+			return nil, fmt.Errorf("invalid master key %s: %w", id, err)
+		}
+	}
+	return &StaticKeyProvider{current: current, keys: keys}, nil
+}
+
+// GenerateDataKey returns a random data key encrypted under the current master key
+func (p *StaticKeyProvider) GenerateDataKey(ctx context.Context) (*DataKey, error) {
+	plaintext := make([]byte, 32)
+	if _, err := io.ReadFull(rand.Reader, plaintext); err != nil {
+		return nil, err
+	}
+	encrypted, err := seal(p.keys[p.current], plaintext)
+	if err != nil {
+		return nil, err
+	}
+	return &DataKey{Plaintext: plaintext, Encrypted: encrypted, KeyID: p.current}, nil
+}
+
+// DecryptDataKey decrypts the data key with the master key with the given ID
+func (p *StaticKeyProvider) DecryptDataKey(ctx context.Context, keyID string, encrypted []byte) ([]byte, error) {
+	key, ok := p.keys[keyID]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownKey, keyID)
+	}
+	return open(key, encrypted)
+}
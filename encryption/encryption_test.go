@@ -0,0 +1,54 @@
+package encryption
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestEncrypt(t *testing.T) {
+	oldKey := bytes.Repeat([]byte{1}, 32)
+	newKey := bytes.Repeat([]byte{2}, 32)
+	before, err := NewStaticKeyProvider("old", map[string][]byte{"old": oldKey})
+	require.NoError(t, err)
+
+	ciphertext, key, err := Encrypt(context.TODO(), before, []byte("message"))
+	require.NoError(t, err)
+	require.Equal(t, "old", key.KeyID)
+	require.NotContains(t, string(ciphertext), "message")
+
+	plaintext, err := Decrypt(context.TODO(), before, key.KeyID, key.Encrypted, ciphertext)
+	require.NoError(t, err)
+	require.Equal(t, "message", string(plaintext))
+
+	// Messages encrypted before a rotation are decrypted with the previous key
+	after, err := NewStaticKeyProvider("new", map[string][]byte{"old": oldKey, "new": newKey})
+	require.NoError(t, err)
+	plaintext, err = Decrypt(context.TODO(), after, key.KeyID, key.Encrypted, ciphertext)
+	require.NoError(t, err)
+	require.Equal(t, "message", string(plaintext))
+
+	ciphertext, key, err = Encrypt(context.TODO(), after, []byte("message"))
+	require.NoError(t, err)
+	require.Equal(t, "new", key.KeyID)
+	_, err = Decrypt(context.TODO(), before, key.KeyID, key.Encrypted, ciphertext)
+	require.True(t, errors.Is(err, ErrUnknownKey))
+
+	// Tampered messages are not decrypted
+	ciphertext[len(ciphertext)-1] ^= 1
+	_, err = Decrypt(context.TODO(), after, key.KeyID, key.Encrypted, ciphertext)
+	require.EqualError(t, err, "cipher: message authentication failed")
+	_, err = Decrypt(context.TODO(), after, key.KeyID, key.Encrypted, []byte{0})
+	require.EqualError(t, err, "ciphertext too short")
+}
+
+func TestNewStaticKeyProvider(t *testing.T) {
+	_, err := NewStaticKeyProvider("missing", map[string][]byte{"key": bytes.Repeat([]byte{1}, 32)})
+	require.True(t, errors.Is(err, ErrUnknownKey))
+
+	_, err = NewStaticKeyProvider("key", map[string][]byte{"key": []byte("short")})
+	require.EqualError(t, err, "invalid master key key: crypto/aes: invalid key size 5")
+}
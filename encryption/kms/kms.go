@@ -0,0 +1,81 @@
+// Package kms provides an encryption.KeyProvider generating the data keys with AWS KMS
+package kms
+
+import (
+	"context"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/kms"
+
+	"github.com/bernardopericacho/htsqs/encryption"
+)
+
+// KMSClient is the subset of kmsiface.KMSAPI used by the provider
+type KMSClient interface {
+	GenerateDataKeyWithContext(ctx aws.Context, input *kms.GenerateDataKeyInput, opts ...request.Option) (*kms.GenerateDataKeyOutput, error)
+	DecryptWithContext(ctx aws.Context, input *kms.DecryptInput, opts ...request.Option) (*kms.DecryptOutput, error)
+}
+
+// Config holds the info required to generate the data keys with AWS KMS
+type Config struct {
+
+	// AWS session. Not used when KMSClient is set
+	AWSSession *session.Session
+
+	// Client used to generate and decrypt the data keys. Built from AWSSession by default
+	KMSClient KMSClient
+
+	// ID, ARN or alias of the KMS key the data keys are generated under. Data keys generated under other keys
+	// are decrypted as long as the caller is allowed to use them, so the key can be changed at any time.
+	// KMS keys with automatic rotation keep decrypting the data keys generated before every rotation
+	KeyID string
+}
+
+// KeyProvider generates the data keys with AWS KMS. Every message requires a GenerateDataKey request
+// to be published and a Decrypt request to be received
+type KeyProvider struct {
+	kms KMSClient
+	cfg Config
+}
+
+// GenerateDataKey generates an AES-256 data key under the configured KMS key
+func (p *KeyProvider) GenerateDataKey(ctx context.Context) (*encryption.DataKey, error) {
+	output, err := p.kms.GenerateDataKeyWithContext(ctx, &kms.GenerateDataKeyInput{
+		KeyId:   &p.cfg.KeyID,
+		KeySpec: aws.String(kms.DataKeySpecAes256),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &encryption.DataKey{Plaintext: output.Plaintext, Encrypted: output.CiphertextBlob, KeyID: aws.StringValue(output.KeyId)}, nil
+}
+
+// DecryptDataKey decrypts the data key with the KMS key with the given ARN
+func (p *KeyProvider) DecryptDataKey(ctx context.Context, keyID string, encrypted []byte) ([]byte, error) {
+	output, err := p.kms.DecryptWithContext(ctx, &kms.DecryptInput{
+		KeyId:          &keyID,
+		CiphertextBlob: encrypted,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return output.Plaintext, nil
+}
+
+func defaultKeyProviderConfig(cfg *Config) {
+	if cfg.AWSSession == nil && cfg.KMSClient == nil {
+		cfg.AWSSession = session.Must(session.NewSession())
+	}
+}
+
+// New creates a new AWS KMS key provider
+func New(cfg Config) *KeyProvider {
+	defaultKeyProviderConfig(&cfg)
+	client := cfg.KMSClient
+	if client == nil {
+		client = kms.New(cfg.AWSSession)
+	}
+	return &KeyProvider{cfg: cfg, kms: client}
+}
@@ -0,0 +1,48 @@
+package kms
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/kms"
+	"github.com/stretchr/testify/require"
+
+	"github.com/bernardopericacho/htsqs/encryption"
+)
+
+const keyArn = "arn:aws:kms:us-west-2:123456789012:key/1234abcd-12ab-34cd-56ef-1234567890ab"
+
+// kmsMock "encrypts" the data keys by reversing them
+type kmsMock struct{}
+
+func (kmsMock) GenerateDataKeyWithContext(ctx aws.Context, input *kms.GenerateDataKeyInput, opts ...request.Option) (*kms.GenerateDataKeyOutput, error) {
+	plaintext := bytes.Repeat([]byte{1, 2}, 16)
+	return &kms.GenerateDataKeyOutput{Plaintext: plaintext, CiphertextBlob: reverse(plaintext), KeyId: aws.String(keyArn)}, nil
+}
+
+func (kmsMock) DecryptWithContext(ctx aws.Context, input *kms.DecryptInput, opts ...request.Option) (*kms.DecryptOutput, error) {
+	return &kms.DecryptOutput{Plaintext: reverse(input.CiphertextBlob), KeyId: input.KeyId}, nil
+}
+
+func reverse(b []byte) []byte {
+	r := make([]byte, len(b))
+	for i := range b {
+		r[len(b)-1-i] = b[i]
+	}
+	return r
+}
+
+func TestKeyProvider(t *testing.T) {
+	p := New(Config{KMSClient: kmsMock{}, KeyID: "alias/htsqs"})
+
+	ciphertext, key, err := encryption.Encrypt(context.TODO(), p, []byte("message"))
+	require.NoError(t, err)
+	require.Equal(t, keyArn, key.KeyID)
+
+	plaintext, err := encryption.Decrypt(context.TODO(), p, key.KeyID, key.Encrypted, ciphertext)
+	require.NoError(t, err)
+	require.Equal(t, "message", string(plaintext))
+}
@@ -3,7 +3,16 @@ module github.com/bernardopericacho/htsqs
 go 1.13
 
 require (
-	github.com/aws/aws-sdk-go v1.38.40
+	github.com/aws/aws-sdk-go v1.44.0
 	github.com/jpillora/backoff v1.0.0
+	github.com/klauspost/compress v1.13.6
+	github.com/mattn/go-sqlite3 v1.14.16 // indirect
+	github.com/prometheus/client_golang v1.11.1
 	github.com/stretchr/testify v1.7.0
+	github.com/vmihailenco/msgpack/v5 v5.3.5
+	go.opentelemetry.io/otel v1.0.0
+	go.opentelemetry.io/otel/sdk v1.0.0
+	go.opentelemetry.io/otel/trace v1.0.0
+	google.golang.org/protobuf v1.27.1
+	modernc.org/sqlite v1.20.4
 )
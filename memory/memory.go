@@ -0,0 +1,604 @@
+// Package memory provides an in-memory implementation of AWS SQS queues and AWS SNS topics to run subscribers
+// and publishers in tests and local development, without reaching AWS.
+//
+// Broker implements the SQS and SNS clients used by the subscriber and the publishers:
+//
+//	broker := memory.NewBroker()
+//	queueURL := broker.CreateQueue("orders", memory.QueueConfig{})
+//	topicArn := broker.CreateTopic("events")
+//	broker.Subscribe(topicArn, queueURL, true)
+//
+//	pub := sns.New(sns.Config{TopicArn: topicArn, SNSClient: broker})
+//	subs := subscriber.New(subscriber.Config{SqsQueueURL: queueURL, SQSClient: broker})
+//
+// Queues support visibility timeouts, receive counts, delays, long polling and the redrive of the messages
+// received too many times to a dead-letter queue. Topics fan messages out to the subscribed queues,
+// wrapped in SNS notifications unless raw message delivery is enabled.
+package memory
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/sns"
+	"github.com/aws/aws-sdk-go/service/sqs"
+)
+
+const (
+	// defaultVisibilityTimeout is the visibility timeout of the queues, as in AWS SQS
+	defaultVisibilityTimeout = 30 * time.Second
+
+	// maxMessagesPerReceive is the maximum number of messages returned by a receive request
+	maxMessagesPerReceive = 10
+
+	// queueURLPrefix is the prefix of the URLs of the in-memory queues
+	queueURLPrefix = "memory://sqs/"
+
+	// topicArnPrefix is the prefix of the ARNs of the in-memory topics
+	topicArnPrefix = "arn:aws:sns:memory:000000000000:"
+)
+
+// QueueConfig holds the settings of an in-memory queue
+type QueueConfig struct {
+
+	// The duration the received messages are hidden from subsequent receive requests.
+	// 30 seconds by default
+	VisibilityTimeout time.Duration
+
+	// The duration the sent messages are hidden before being delivered for the first time,
+	// unless the message sets its own delay
+	Delay time.Duration
+
+	// Number of receives after which a message is moved to the dead-letter queue
+	// instead of being delivered again. Disabled when zero
+	MaxReceiveCount int
+
+	// URL of the dead-letter queue the messages are moved to once they reach MaxReceiveCount
+	DeadLetterQueueURL string
+}
+
+// Broker holds in-memory queues and topics. It implements the subscriber and publisher clients
+// and is safe for concurrent use
+type Broker struct {
+	mu     sync.Mutex
+	queues map[string]*queue
+	topics map[string][]subscription
+	// closed and replaced whenever a message is sent, to wake up the long polling receive requests
+	sent chan struct{}
+}
+
+// NewBroker creates an in-memory broker without queues or topics
+func NewBroker() *Broker {
+	return &Broker{queues: make(map[string]*queue), topics: make(map[string][]subscription), sent: make(chan struct{})}
+}
+
+// CreateQueue creates a queue with the given name and returns its URL. Queues whose name ends with .fifo
+// do not deliver a message while another message of the same message group is in flight.
+// Creating an existing queue updates its settings
+func (b *Broker) CreateQueue(name string, cfg QueueConfig) string {
+	if cfg.VisibilityTimeout == 0 {
+		cfg.VisibilityTimeout = defaultVisibilityTimeout
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	url := queueURLPrefix + name
+	if q, ok := b.queues[url]; ok {
+		q.cfg = cfg
+		return url
+	}
+	b.queues[url] = &queue{url: url, cfg: cfg, fifo: strings.HasSuffix(name, ".fifo")}
+	return url
+}
+
+// CreateTopic creates a topic with the given name and returns its ARN
+func (b *Broker) CreateTopic(name string) string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	arn := topicArnPrefix + name
+	if _, ok := b.topics[arn]; !ok {
+		b.topics[arn] = nil
+	}
+	return arn
+}
+
+// Subscribe subscribes the queue to the topic. Messages published to the topic are delivered to the queue
+// as they are when rawDelivery is true, and wrapped in an SNS notification otherwise
+func (b *Broker) Subscribe(topicArn, queueURL string, rawDelivery bool) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := b.topics[topicArn]; !ok {
+		return topicNotFound(topicArn)
+	}
+	if _, ok := b.queues[queueURL]; !ok {
+		return queueNotFound(queueURL)
+	}
+	b.topics[topicArn] = append(b.topics[topicArn], subscription{queueURL: queueURL, rawDelivery: rawDelivery})
+	return nil
+}
+
+// Len returns the number of messages held by the queue, including the delayed and in-flight ones
+func (b *Broker) Len(queueURL string) int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if q, ok := b.queues[queueURL]; ok {
+		return len(q.messages)
+	}
+	return 0
+}
+
+// SendMessageWithContext implements the SQS clients of the publisher
+func (b *Broker) SendMessageWithContext(ctx aws.Context, input *sqs.SendMessageInput, opts ...request.Option) (*sqs.SendMessageOutput, error) {
+	if err := input.Validate(); err != nil {
+		return nil, err
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	q, ok := b.queues[aws.StringValue(input.QueueUrl)]
+	if !ok {
+		return nil, queueNotFound(aws.StringValue(input.QueueUrl))
+	}
+	m := b.send(q, aws.StringValue(input.MessageBody), input.MessageAttributes, input.DelaySeconds, input.MessageGroupId, input.MessageDeduplicationId)
+	return &sqs.SendMessageOutput{MessageId: aws.String(m.id)}, nil
+}
+
+// SendMessageBatchWithContext implements the SQS clients of the publisher
+func (b *Broker) SendMessageBatchWithContext(ctx aws.Context, input *sqs.SendMessageBatchInput, opts ...request.Option) (*sqs.SendMessageBatchOutput, error) {
+	if err := input.Validate(); err != nil {
+		return nil, err
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	q, ok := b.queues[aws.StringValue(input.QueueUrl)]
+	if !ok {
+		return nil, queueNotFound(aws.StringValue(input.QueueUrl))
+	}
+	output := &sqs.SendMessageBatchOutput{}
+	for _, entry := range input.Entries {
+		m := b.send(q, aws.StringValue(entry.MessageBody), entry.MessageAttributes, entry.DelaySeconds, entry.MessageGroupId, entry.MessageDeduplicationId)
+		output.Successful = append(output.Successful, &sqs.SendMessageBatchResultEntry{Id: entry.Id, MessageId: aws.String(m.id)})
+	}
+	return output, nil
+}
+
+// ReceiveMessageWithContext implements the SQS client of the subscriber.
+// Waits up to WaitTimeSeconds for a message to be available, or until the context is done
+func (b *Broker) ReceiveMessageWithContext(ctx aws.Context, input *sqs.ReceiveMessageInput, opts ...request.Option) (*sqs.ReceiveMessageOutput, error) {
+	if err := input.Validate(); err != nil {
+		return nil, err
+	}
+
+	deadline := time.Now().Add(time.Duration(aws.Int64Value(input.WaitTimeSeconds)) * time.Second)
+	for {
+		b.mu.Lock()
+		q, ok := b.queues[aws.StringValue(input.QueueUrl)]
+		if !ok {
+			b.mu.Unlock()
+			return nil, queueNotFound(aws.StringValue(input.QueueUrl))
+		}
+		now := time.Now()
+		output := b.receive(q, input, now)
+		next, sent := q.nextVisible(now), b.sent
+		b.mu.Unlock()
+
+		if len(output.Messages) > 0 || !now.Before(deadline) {
+			return output, nil
+		}
+
+		// Wait for a message to be sent or to become visible, up to the deadline
+		wait := deadline.Sub(now)
+		if !next.IsZero() && next.Sub(now) < wait {
+			wait = next.Sub(now)
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, awserr.New(request.CanceledErrorCode, "request context canceled", ctx.Err())
+		case <-sent:
+		case <-timer.C:
+		}
+		timer.Stop()
+	}
+}
+
+// DeleteMessageWithContext implements the SQS client of the subscriber
+func (b *Broker) DeleteMessageWithContext(ctx aws.Context, input *sqs.DeleteMessageInput, opts ...request.Option) (*sqs.DeleteMessageOutput, error) {
+	if err := input.Validate(); err != nil {
+		return nil, err
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	q, ok := b.queues[aws.StringValue(input.QueueUrl)]
+	if !ok {
+		return nil, queueNotFound(aws.StringValue(input.QueueUrl))
+	}
+	if !q.delete(aws.StringValue(input.ReceiptHandle)) {
+		return nil, invalidReceiptHandle(aws.StringValue(input.ReceiptHandle))
+	}
+	return &sqs.DeleteMessageOutput{}, nil
+}
+
+// DeleteMessageBatchWithContext implements the SQS client of the subscriber
+func (b *Broker) DeleteMessageBatchWithContext(ctx aws.Context, input *sqs.DeleteMessageBatchInput, opts ...request.Option) (*sqs.DeleteMessageBatchOutput, error) {
+	if err := input.Validate(); err != nil {
+		return nil, err
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	q, ok := b.queues[aws.StringValue(input.QueueUrl)]
+	if !ok {
+		return nil, queueNotFound(aws.StringValue(input.QueueUrl))
+	}
+	output := &sqs.DeleteMessageBatchOutput{}
+	for _, entry := range input.Entries {
+		if !q.delete(aws.StringValue(entry.ReceiptHandle)) {
+			output.Failed = append(output.Failed, &sqs.BatchResultErrorEntry{
+				Id:          entry.Id,
+				Code:        aws.String(sqs.ErrCodeReceiptHandleIsInvalid),
+				Message:     aws.String("The receipt handle is not valid"),
+				SenderFault: aws.Bool(true),
+			})
+			continue
+		}
+		output.Successful = append(output.Successful, &sqs.DeleteMessageBatchResultEntry{Id: entry.Id})
+	}
+	return output, nil
+}
+
+// ChangeMessageVisibilityWithContext implements the SQS client of the subscriber
+func (b *Broker) ChangeMessageVisibilityWithContext(ctx aws.Context, input *sqs.ChangeMessageVisibilityInput, opts ...request.Option) (*sqs.ChangeMessageVisibilityOutput, error) {
+	if err := input.Validate(); err != nil {
+		return nil, err
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	q, ok := b.queues[aws.StringValue(input.QueueUrl)]
+	if !ok {
+		return nil, queueNotFound(aws.StringValue(input.QueueUrl))
+	}
+	m := q.find(aws.StringValue(input.ReceiptHandle))
+	if m == nil {
+		return nil, invalidReceiptHandle(aws.StringValue(input.ReceiptHandle))
+	}
+	m.visibleAt = time.Now().Add(time.Duration(aws.Int64Value(input.VisibilityTimeout)) * time.Second)
+	if aws.Int64Value(input.VisibilityTimeout) == 0 {
+		b.notify()
+	}
+	return &sqs.ChangeMessageVisibilityOutput{}, nil
+}
+
+// PublishWithContext implements the SNS client of the publisher
+func (b *Broker) PublishWithContext(ctx aws.Context, input *sns.PublishInput, opts ...request.Option) (*sns.PublishOutput, error) {
+	if err := input.Validate(); err != nil {
+		return nil, err
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	id, err := b.publish(aws.StringValue(input.TopicArn), aws.StringValue(input.Subject), aws.StringValue(input.Message), input.MessageAttributes, input.MessageGroupId, input.MessageDeduplicationId)
+	if err != nil {
+		return nil, err
+	}
+	return &sns.PublishOutput{MessageId: aws.String(id)}, nil
+}
+
+// PublishBatchWithContext implements the SNS client of the publisher
+func (b *Broker) PublishBatchWithContext(ctx aws.Context, input *sns.PublishBatchInput, opts ...request.Option) (*sns.PublishBatchOutput, error) {
+	if err := input.Validate(); err != nil {
+		return nil, err
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := b.topics[aws.StringValue(input.TopicArn)]; !ok {
+		return nil, topicNotFound(aws.StringValue(input.TopicArn))
+	}
+	output := &sns.PublishBatchOutput{}
+	for _, entry := range input.PublishBatchRequestEntries {
+		id, _ := b.publish(aws.StringValue(input.TopicArn), aws.StringValue(entry.Subject), aws.StringValue(entry.Message), entry.MessageAttributes, entry.MessageGroupId, entry.MessageDeduplicationId)
+		output.Successful = append(output.Successful, &sns.PublishBatchResultEntry{Id: entry.Id, MessageId: aws.String(id)})
+	}
+	return output, nil
+}
+
+// send adds a message to the queue and wakes up the long polling receive requests. Must be called with the lock held
+func (b *Broker) send(q *queue, body string, attributes map[string]*sqs.MessageAttributeValue, delaySeconds *int64, groupID, deduplicationID *string) *message {
+	now := time.Now()
+	delay := q.cfg.Delay
+	if delaySeconds != nil {
+		delay = time.Duration(*delaySeconds) * time.Second
+	}
+
+	m := &message{
+		id:         newID(),
+		body:       body,
+		attributes: attributes,
+		systemAttributes: map[string]*string{
+			sqs.MessageSystemAttributeNameSentTimestamp: aws.String(strconv.FormatInt(now.UnixNano()/int64(time.Millisecond), 10)),
+		},
+		visibleAt: now.Add(delay),
+	}
+	if groupID != nil {
+		m.systemAttributes[sqs.MessageSystemAttributeNameMessageGroupId] = groupID
+	}
+	if deduplicationID != nil {
+		m.systemAttributes[sqs.MessageSystemAttributeNameMessageDeduplicationId] = deduplicationID
+	}
+	q.messages = append(q.messages, m)
+	b.notify()
+	return m
+}
+
+// receive returns the visible messages of the queue, hiding them for the visibility timeout and moving the messages
+// that reached the maximum receive count to the dead-letter queue. Must be called with the lock held
+func (b *Broker) receive(q *queue, input *sqs.ReceiveMessageInput, now time.Time) *sqs.ReceiveMessageOutput {
+	max := int(aws.Int64Value(input.MaxNumberOfMessages))
+	if max <= 0 {
+		max = 1
+	}
+	if max > maxMessagesPerReceive {
+		max = maxMessagesPerReceive
+	}
+	visibilityTimeout := q.cfg.VisibilityTimeout
+	if input.VisibilityTimeout != nil {
+		visibilityTimeout = time.Duration(*input.VisibilityTimeout) * time.Second
+	}
+
+	output := &sqs.ReceiveMessageOutput{Messages: []*sqs.Message{}}
+	blockedGroups := make(map[string]bool)
+	for _, m := range append([]*message(nil), q.messages...) {
+		if len(output.Messages) == max {
+			break
+		}
+
+		// Messages of a FIFO message group are delivered one at a time and in order
+		groupID := aws.StringValue(m.systemAttributes[sqs.MessageSystemAttributeNameMessageGroupId])
+		if q.fifo && groupID != "" {
+			if blockedGroups[groupID] {
+				continue
+			}
+			blockedGroups[groupID] = true
+		}
+		if m.visibleAt.After(now) {
+			continue
+		}
+
+		if q.cfg.MaxReceiveCount > 0 && m.receiveCount >= q.cfg.MaxReceiveCount {
+			if dlq, ok := b.queues[q.cfg.DeadLetterQueueURL]; ok {
+				q.remove(m)
+				m.receiveCount, m.receiptHandle, m.visibleAt = 0, "", now
+				dlq.messages = append(dlq.messages, m)
+				delete(blockedGroups, groupID)
+				b.notify()
+				continue
+			}
+		}
+
+		m.receiveCount++
+		m.receiptHandle = fmt.Sprintf("%s#%d", m.id, m.receiveCount)
+		m.visibleAt = now.Add(visibilityTimeout)
+		output.Messages = append(output.Messages, m.sqsMessage(input))
+	}
+	return output
+}
+
+// publish fans the message out to the queues subscribed to the topic. Must be called with the lock held
+func (b *Broker) publish(topicArn, subject, body string, attributes map[string]*sns.MessageAttributeValue, groupID, deduplicationID *string) (string, error) {
+	subscriptions, ok := b.topics[topicArn]
+	if !ok {
+		return "", topicNotFound(topicArn)
+	}
+
+	id := newID()
+	for _, s := range subscriptions {
+		q := b.queues[s.queueURL]
+		if s.rawDelivery {
+			b.send(q, body, sqsAttributes(attributes), nil, groupID, deduplicationID)
+			continue
+		}
+		b.send(q, notification(id, topicArn, subject, body, attributes), nil, nil, groupID, deduplicationID)
+	}
+	return id, nil
+}
+
+// notify wakes up the long polling receive requests. Must be called with the lock held
+func (b *Broker) notify() {
+	close(b.sent)
+	b.sent = make(chan struct{})
+}
+
+// subscription is the subscription of a queue to a topic
+type subscription struct {
+	queueURL    string
+	rawDelivery bool
+}
+
+// queue is an in-memory queue. Its messages are kept in the order they were sent
+type queue struct {
+	url      string
+	cfg      QueueConfig
+	fifo     bool
+	messages []*message
+}
+
+// find returns the message with the given receipt handle, nil if there is none
+func (q *queue) find(receiptHandle string) *message {
+	for _, m := range q.messages {
+		if m.receiptHandle != "" && m.receiptHandle == receiptHandle {
+			return m
+		}
+	}
+	return nil
+}
+
+// delete deletes the message with the given receipt handle. Returns false if there is none
+func (q *queue) delete(receiptHandle string) bool {
+	m := q.find(receiptHandle)
+	if m == nil {
+		return false
+	}
+	q.remove(m)
+	return true
+}
+
+// remove removes the message from the queue
+func (q *queue) remove(m *message) {
+	for i := range q.messages {
+		if q.messages[i] == m {
+			q.messages = append(q.messages[:i], q.messages[i+1:]...)
+			return
+		}
+	}
+}
+
+// nextVisible returns the earliest time a hidden message becomes visible, zero if there is none
+func (q *queue) nextVisible(now time.Time) time.Time {
+	var next time.Time
+	for _, m := range q.messages {
+		if m.visibleAt.After(now) && (next.IsZero() || m.visibleAt.Before(next)) {
+			next = m.visibleAt
+		}
+	}
+	return next
+}
+
+// message is a message held by an in-memory queue
+type message struct {
+	id               string
+	body             string
+	attributes       map[string]*sqs.MessageAttributeValue
+	systemAttributes map[string]*string
+	receiveCount     int
+	receiptHandle    string
+	visibleAt        time.Time
+}
+
+// sqsMessage returns the message as it is returned by a receive request with the given settings
+func (m *message) sqsMessage(input *sqs.ReceiveMessageInput) *sqs.Message {
+	out := &sqs.Message{
+		MessageId:     aws.String(m.id),
+		ReceiptHandle: aws.String(m.receiptHandle),
+		Body:          aws.String(m.body),
+	}
+
+	for name, value := range m.systemAttributes {
+		if requested(input.AttributeNames, name) {
+			if out.Attributes == nil {
+				out.Attributes = make(map[string]*string)
+			}
+			out.Attributes[name] = value
+		}
+	}
+	if requested(input.AttributeNames, sqs.MessageSystemAttributeNameApproximateReceiveCount) {
+		if out.Attributes == nil {
+			out.Attributes = make(map[string]*string)
+		}
+		out.Attributes[sqs.MessageSystemAttributeNameApproximateReceiveCount] = aws.String(strconv.Itoa(m.receiveCount))
+	}
+
+	for name, value := range m.attributes {
+		if requested(input.MessageAttributeNames, name) {
+			if out.MessageAttributes == nil {
+				out.MessageAttributes = make(map[string]*sqs.MessageAttributeValue)
+			}
+			out.MessageAttributes[name] = value
+		}
+	}
+	return out
+}
+
+// requested reports whether the attribute is in the requested attribute names
+func requested(names []*string, name string) bool {
+	for _, n := range names {
+		if v := aws.StringValue(n); v == name || v == sqs.QueueAttributeNameAll || v == ".*" {
+			return true
+		}
+	}
+	return false
+}
+
+// sqsAttributes converts SNS message attributes to SQS message attributes
+func sqsAttributes(attributes map[string]*sns.MessageAttributeValue) map[string]*sqs.MessageAttributeValue {
+	if len(attributes) == 0 {
+		return nil
+	}
+	values := make(map[string]*sqs.MessageAttributeValue, len(attributes))
+	for name, value := range attributes {
+		values[name] = &sqs.MessageAttributeValue{DataType: value.DataType, StringValue: value.StringValue, BinaryValue: value.BinaryValue}
+	}
+	return values
+}
+
+// notification returns the SNS notification delivered to the queues subscribed without raw message delivery.
+// Notifications are not signed
+func notification(id, topicArn, subject, body string, attributes map[string]*sns.MessageAttributeValue) string {
+	type attribute struct {
+		Type  string
+		Value string
+	}
+	n := struct {
+		Type              string
+		MessageId         string
+		TopicArn          string
+		Subject           string `json:",omitempty"`
+		Message           string
+		Timestamp         string
+		MessageAttributes map[string]attribute `json:",omitempty"`
+	}{
+		Type:      "Notification",
+		MessageId: id,
+		TopicArn:  topicArn,
+		Subject:   subject,
+		Message:   body,
+		Timestamp: time.Now().UTC().Format("2006-01-02T15:04:05.000Z"),
+	}
+	for name, value := range attributes {
+		if n.MessageAttributes == nil {
+			n.MessageAttributes = make(map[string]attribute)
+		}
+		a := attribute{Type: aws.StringValue(value.DataType), Value: aws.StringValue(value.StringValue)}
+		if value.BinaryValue != nil {
+			a.Value = base64.StdEncoding.EncodeToString(value.BinaryValue)
+		}
+		n.MessageAttributes[name] = a
+	}
+
+	b, _ := json.Marshal(n)
+	return string(b)
+}
+
+// newID returns a random identifier formatted as a UUID
+func newID() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	s := hex.EncodeToString(b)
+	return fmt.Sprintf("%s-%s-%s-%s-%s", s[:8], s[8:12], s[12:16], s[16:20], s[20:])
+}
+
+func queueNotFound(url string) error {
+	return awserr.New(sqs.ErrCodeQueueDoesNotExist, fmt.Sprintf("The specified queue %s does not exist", url), nil)
+}
+
+func topicNotFound(arn string) error {
+	return awserr.New(sns.ErrCodeNotFoundException, fmt.Sprintf("Topic %s does not exist", arn), nil)
+}
+
+func invalidReceiptHandle(receiptHandle string) error {
+	return awserr.New(sqs.ErrCodeReceiptHandleIsInvalid, fmt.Sprintf("The receipt handle %s is not valid", receiptHandle), nil)
+}
@@ -0,0 +1,353 @@
+package memory
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/sns"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/stretchr/testify/require"
+
+	"github.com/bernardopericacho/htsqs/codec"
+	"github.com/bernardopericacho/htsqs/publisher"
+	snspublisher "github.com/bernardopericacho/htsqs/publisher/sns"
+	sqspublisher "github.com/bernardopericacho/htsqs/publisher/sqs"
+	"github.com/bernardopericacho/htsqs/subscriber"
+)
+
+func receive(t *testing.T, b *Broker, queueURL string, max int64) []*sqs.Message {
+	output, err := b.ReceiveMessageWithContext(context.TODO(), &sqs.ReceiveMessageInput{
+		QueueUrl:              aws.String(queueURL),
+		MaxNumberOfMessages:   aws.Int64(max),
+		AttributeNames:        []*string{aws.String(sqs.QueueAttributeNameAll)},
+		MessageAttributeNames: []*string{aws.String(sqs.QueueAttributeNameAll)},
+	})
+	require.NoError(t, err)
+	return output.Messages
+}
+
+func send(t *testing.T, b *Broker, queueURL, body string) {
+	_, err := b.SendMessageWithContext(context.TODO(), &sqs.SendMessageInput{QueueUrl: aws.String(queueURL), MessageBody: aws.String(body)})
+	require.NoError(t, err)
+}
+
+func TestBrokerQueue(t *testing.T) {
+	b := NewBroker()
+	queueURL := b.CreateQueue("queue", QueueConfig{VisibilityTimeout: 50 * time.Millisecond})
+
+	_, err := b.SendMessageWithContext(context.TODO(), &sqs.SendMessageInput{
+		QueueUrl:          aws.String(queueURL),
+		MessageBody:       aws.String("message"),
+		MessageAttributes: map[string]*sqs.MessageAttributeValue{"event": {DataType: aws.String("String"), StringValue: aws.String("created")}},
+	})
+	require.NoError(t, err)
+	require.Equal(t, 1, b.Len(queueURL))
+
+	msgs := receive(t, b, queueURL, 10)
+	require.Len(t, msgs, 1)
+	require.Equal(t, "message", aws.StringValue(msgs[0].Body))
+	require.Equal(t, "created", aws.StringValue(msgs[0].MessageAttributes["event"].StringValue))
+	require.Equal(t, "1", aws.StringValue(msgs[0].Attributes[sqs.MessageSystemAttributeNameApproximateReceiveCount]))
+	require.NotEmpty(t, aws.StringValue(msgs[0].Attributes[sqs.MessageSystemAttributeNameSentTimestamp]))
+
+	// The message is hidden until its visibility timeout expires
+	require.Empty(t, receive(t, b, queueURL, 10))
+	time.Sleep(60 * time.Millisecond)
+	msgs = receive(t, b, queueURL, 10)
+	require.Len(t, msgs, 1)
+	require.Equal(t, "2", aws.StringValue(msgs[0].Attributes[sqs.MessageSystemAttributeNameApproximateReceiveCount]))
+
+	// Changing the visibility to zero makes it visible right away
+	_, err = b.ChangeMessageVisibilityWithContext(context.TODO(), &sqs.ChangeMessageVisibilityInput{
+		QueueUrl:          aws.String(queueURL),
+		ReceiptHandle:     msgs[0].ReceiptHandle,
+		VisibilityTimeout: aws.Int64(0),
+	})
+	require.NoError(t, err)
+	msgs = receive(t, b, queueURL, 10)
+	require.Len(t, msgs, 1)
+
+	// Receipt handles of previous receives are no longer valid
+	_, err = b.DeleteMessageWithContext(context.TODO(), &sqs.DeleteMessageInput{QueueUrl: aws.String(queueURL), ReceiptHandle: aws.String("invalid")})
+	require.Equal(t, sqs.ErrCodeReceiptHandleIsInvalid, err.(awserr.Error).Code())
+
+	_, err = b.DeleteMessageWithContext(context.TODO(), &sqs.DeleteMessageInput{QueueUrl: aws.String(queueURL), ReceiptHandle: msgs[0].ReceiptHandle})
+	require.NoError(t, err)
+	require.Equal(t, 0, b.Len(queueURL))
+
+	_, err = b.SendMessageWithContext(context.TODO(), &sqs.SendMessageInput{QueueUrl: aws.String("memory://sqs/unknown"), MessageBody: aws.String("message")})
+	require.Equal(t, sqs.ErrCodeQueueDoesNotExist, err.(awserr.Error).Code())
+}
+
+func TestBrokerBatch(t *testing.T) {
+	b := NewBroker()
+	queueURL := b.CreateQueue("queue", QueueConfig{})
+
+	output, err := b.SendMessageBatchWithContext(context.TODO(), &sqs.SendMessageBatchInput{
+		QueueUrl: aws.String(queueURL),
+		Entries: []*sqs.SendMessageBatchRequestEntry{
+			{Id: aws.String("0"), MessageBody: aws.String("first")},
+			{Id: aws.String("1"), MessageBody: aws.String("second")},
+			{Id: aws.String("2"), MessageBody: aws.String("third")},
+		},
+	})
+	require.NoError(t, err)
+	require.Len(t, output.Successful, 3)
+
+	msgs := receive(t, b, queueURL, 2)
+	require.Len(t, msgs, 2)
+	require.Equal(t, "first", aws.StringValue(msgs[0].Body))
+	require.Equal(t, "second", aws.StringValue(msgs[1].Body))
+
+	deleted, err := b.DeleteMessageBatchWithContext(context.TODO(), &sqs.DeleteMessageBatchInput{
+		QueueUrl: aws.String(queueURL),
+		Entries: []*sqs.DeleteMessageBatchRequestEntry{
+			{Id: aws.String("0"), ReceiptHandle: msgs[0].ReceiptHandle},
+			{Id: aws.String("1"), ReceiptHandle: aws.String("invalid")},
+		},
+	})
+	require.NoError(t, err)
+	require.Len(t, deleted.Successful, 1)
+	require.Len(t, deleted.Failed, 1)
+	require.Equal(t, "1", aws.StringValue(deleted.Failed[0].Id))
+	require.Equal(t, 2, b.Len(queueURL))
+}
+
+func TestBrokerDelay(t *testing.T) {
+	b := NewBroker()
+	queueURL := b.CreateQueue("queue", QueueConfig{Delay: 50 * time.Millisecond})
+
+	send(t, b, queueURL, "delayed")
+	_, err := b.SendMessageWithContext(context.TODO(), &sqs.SendMessageInput{
+		QueueUrl:     aws.String(queueURL),
+		MessageBody:  aws.String("not delayed"),
+		DelaySeconds: aws.Int64(0),
+	})
+	require.NoError(t, err)
+
+	msgs := receive(t, b, queueURL, 10)
+	require.Len(t, msgs, 1)
+	require.Equal(t, "not delayed", aws.StringValue(msgs[0].Body))
+
+	time.Sleep(60 * time.Millisecond)
+	msgs = receive(t, b, queueURL, 10)
+	require.Len(t, msgs, 1)
+	require.Equal(t, "delayed", aws.StringValue(msgs[0].Body))
+}
+
+func TestBrokerRedrive(t *testing.T) {
+	b := NewBroker()
+	dlqURL := b.CreateQueue("dlq", QueueConfig{})
+	queueURL := b.CreateQueue("queue", QueueConfig{VisibilityTimeout: time.Millisecond, MaxReceiveCount: 2, DeadLetterQueueURL: dlqURL})
+
+	send(t, b, queueURL, "message")
+	for i := 0; i < 2; i++ {
+		require.Len(t, receive(t, b, queueURL, 10), 1)
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	// The message reached the maximum receive count and is moved to the dead-letter queue
+	require.Empty(t, receive(t, b, queueURL, 10))
+	require.Equal(t, 0, b.Len(queueURL))
+
+	msgs := receive(t, b, dlqURL, 10)
+	require.Len(t, msgs, 1)
+	require.Equal(t, "message", aws.StringValue(msgs[0].Body))
+	require.Equal(t, "1", aws.StringValue(msgs[0].Attributes[sqs.MessageSystemAttributeNameApproximateReceiveCount]))
+}
+
+func TestBrokerFIFO(t *testing.T) {
+	b := NewBroker()
+	queueURL := b.CreateQueue("queue.fifo", QueueConfig{})
+
+	for _, m := range []struct{ body, group string }{{"a1", "a"}, {"a2", "a"}, {"b1", "b"}} {
+		_, err := b.SendMessageWithContext(context.TODO(), &sqs.SendMessageInput{
+			QueueUrl:               aws.String(queueURL),
+			MessageBody:            aws.String(m.body),
+			MessageGroupId:         aws.String(m.group),
+			MessageDeduplicationId: aws.String(m.body),
+		})
+		require.NoError(t, err)
+	}
+
+	// A message is not delivered while another one of its group is in flight
+	msgs := receive(t, b, queueURL, 10)
+	require.Len(t, msgs, 2)
+	require.Equal(t, "a1", aws.StringValue(msgs[0].Body))
+	require.Equal(t, "a", aws.StringValue(msgs[0].Attributes[sqs.MessageSystemAttributeNameMessageGroupId]))
+	require.Equal(t, "b1", aws.StringValue(msgs[1].Body))
+	require.Empty(t, receive(t, b, queueURL, 10))
+
+	_, err := b.DeleteMessageWithContext(context.TODO(), &sqs.DeleteMessageInput{QueueUrl: aws.String(queueURL), ReceiptHandle: msgs[0].ReceiptHandle})
+	require.NoError(t, err)
+	msgs = receive(t, b, queueURL, 10)
+	require.Len(t, msgs, 1)
+	require.Equal(t, "a2", aws.StringValue(msgs[0].Body))
+}
+
+func TestBrokerLongPolling(t *testing.T) {
+	b := NewBroker()
+	queueURL := b.CreateQueue("queue", QueueConfig{})
+
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		send(t, b, queueURL, "message")
+	}()
+
+	start := time.Now()
+	output, err := b.ReceiveMessageWithContext(context.TODO(), &sqs.ReceiveMessageInput{QueueUrl: aws.String(queueURL), WaitTimeSeconds: aws.Int64(5)})
+	require.NoError(t, err)
+	require.Len(t, output.Messages, 1)
+	require.Less(t, int64(time.Since(start)), int64(time.Second))
+
+	// Receive requests are canceled with their context
+	ctx, cancel := context.WithTimeout(context.TODO(), 20*time.Millisecond)
+	defer cancel()
+	_, err = b.ReceiveMessageWithContext(ctx, &sqs.ReceiveMessageInput{QueueUrl: aws.String(queueURL), WaitTimeSeconds: aws.Int64(5)})
+	require.Error(t, err)
+}
+
+func TestBrokerTopic(t *testing.T) {
+	b := NewBroker()
+	rawURL := b.CreateQueue("raw", QueueConfig{})
+	envelopeURL := b.CreateQueue("envelope", QueueConfig{})
+	topicArn := b.CreateTopic("topic")
+	require.NoError(t, b.Subscribe(topicArn, rawURL, true))
+	require.NoError(t, b.Subscribe(topicArn, envelopeURL, false))
+	require.Error(t, b.Subscribe("arn:aws:sns:memory:000000000000:unknown", rawURL, true))
+
+	output, err := b.PublishWithContext(context.TODO(), &sns.PublishInput{
+		TopicArn:          aws.String(topicArn),
+		Message:           aws.String("message"),
+		MessageAttributes: map[string]*sns.MessageAttributeValue{"event": {DataType: aws.String("String"), StringValue: aws.String("created")}},
+	})
+	require.NoError(t, err)
+
+	msgs := receive(t, b, rawURL, 10)
+	require.Len(t, msgs, 1)
+	require.Equal(t, "message", aws.StringValue(msgs[0].Body))
+	require.Equal(t, "created", aws.StringValue(msgs[0].MessageAttributes["event"].StringValue))
+
+	msgs = receive(t, b, envelopeURL, 10)
+	require.Len(t, msgs, 1)
+	require.Empty(t, msgs[0].MessageAttributes)
+	var n struct {
+		Type, MessageId, TopicArn, Message, Timestamp string
+		MessageAttributes                             map[string]struct{ Type, Value string }
+	}
+	require.NoError(t, json.Unmarshal([]byte(aws.StringValue(msgs[0].Body)), &n))
+	require.Equal(t, "Notification", n.Type)
+	require.Equal(t, aws.StringValue(output.MessageId), n.MessageId)
+	require.Equal(t, topicArn, n.TopicArn)
+	require.Equal(t, "message", n.Message)
+	require.NotEmpty(t, n.Timestamp)
+	require.Equal(t, map[string]struct{ Type, Value string }{"event": {"String", "created"}}, n.MessageAttributes)
+
+	_, err = b.PublishWithContext(context.TODO(), &sns.PublishInput{TopicArn: aws.String("arn:aws:sns:memory:000000000000:unknown"), Message: aws.String("message")})
+	require.Equal(t, sns.ErrCodeNotFoundException, err.(awserr.Error).Code())
+}
+
+// TestBrokerClients runs the publishers and the subscriber against the broker
+func TestBrokerClients(t *testing.T) {
+	b := NewBroker()
+	queueURL := b.CreateQueue("queue", QueueConfig{})
+	topicArn := b.CreateTopic("topic")
+	require.NoError(t, b.Subscribe(topicArn, queueURL, false))
+
+	sqsPub := sqspublisher.New(sqspublisher.Config{SQSClient: b, QueueURL: queueURL})
+	snsPub := snspublisher.New(snspublisher.Config{SNSClient: b, TopicArn: topicArn})
+	require.NoError(t, sqsPub.Publish(context.TODO(), map[string]string{"from": "sqs"}))
+	require.NoError(t, snsPub.Publish(context.TODO(), map[string]string{"from": "sns"}, publisher.WithAttribute("event", publisher.StringAttribute("created"))))
+	_, err := snsPub.PublishBatch(context.TODO(), []interface{}{map[string]string{"from": "batch"}})
+	require.NoError(t, err)
+
+	subs := subscriber.New(subscriber.Config{
+		SQSClient:           b,
+		SqsQueueURL:         queueURL,
+		TimeoutSeconds:      aws.Int64(1),
+		MaxMessagesPerBatch: aws.Int64(10),
+		UnwrapSNSEnvelope:   true,
+		NumConsumers:        1,
+	})
+
+	var mu sync.Mutex
+	received := make(map[string]string)
+	done := make(chan struct{})
+	worker := subscriber.NewWorker(subscriber.WorkerConfig{
+		Subscriber: subs,
+		Handler: func(ctx context.Context, m *subscriber.SQSMessage) error {
+			var msg map[string]string
+			if err := m.Decode(&msg); err != nil {
+				return err
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			received[msg["from"]] = m.TopicArn()
+			if attr, ok := m.MessageAttributes()["event"]; ok {
+				received["event"] = aws.StringValue(attr.StringValue)
+			}
+			if len(received) == 4 {
+				close(done)
+			}
+			return nil
+		},
+	})
+
+	errCh := make(chan error, 1)
+	go func() { errCh <- worker.Start(context.TODO()) }()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("messages not received")
+	}
+	require.NoError(t, worker.Stop())
+	<-errCh
+
+	require.Equal(t, map[string]string{"sqs": "", "sns": topicArn, "batch": topicArn, "event": "created"}, received)
+	require.Equal(t, 0, b.Len(queueURL))
+}
+
+func TestBrokerDeadLetterFIFO(t *testing.T) {
+	b := NewBroker()
+	queueURL := b.CreateQueue("queue.fifo", QueueConfig{})
+	deadLetterURL := b.CreateQueue("dead-letter.fifo", QueueConfig{})
+
+	pub := sqspublisher.New(sqspublisher.Config{SQSClient: b, QueueURL: queueURL})
+	require.NoError(t, pub.Publish(context.TODO(), "message", publisher.WithCodec(codec.Verbatim("text/plain")), publisher.WithGroupID("group"), publisher.WithDeduplicationID("deduplication")))
+
+	subs := subscriber.New(subscriber.Config{SQSClient: b, SqsQueueURL: queueURL, TimeoutSeconds: aws.Int64(1), NumConsumers: 1})
+	handled := make(chan struct{})
+	worker := subscriber.NewWorker(subscriber.WorkerConfig{
+		Subscriber: subs,
+		Handler: func(ctx context.Context, m *subscriber.SQSMessage) error {
+			close(handled)
+			return subscriber.ErrDeadLetter
+		},
+		DeadLetterPublisher: sqspublisher.New(sqspublisher.Config{SQSClient: b, QueueURL: deadLetterURL}),
+	})
+
+	errCh := make(chan error, 1)
+	go func() { errCh <- worker.Start(context.TODO()) }()
+
+	select {
+	case <-handled:
+	case <-time.After(5 * time.Second):
+		t.Fatal("message not received")
+	}
+	require.NoError(t, worker.Shutdown(context.TODO()))
+	<-errCh
+
+	// The message is forwarded to the FIFO dead-letter queue with its group and deduplication IDs, and deleted
+	require.Equal(t, 0, b.Len(queueURL))
+	msgs := receive(t, b, deadLetterURL, 10)
+	require.Len(t, msgs, 1)
+	require.Equal(t, "message", aws.StringValue(msgs[0].Body))
+	require.Equal(t, "group", aws.StringValue(msgs[0].Attributes[sqs.MessageSystemAttributeNameMessageGroupId]))
+	require.Equal(t, "deduplication", aws.StringValue(msgs[0].Attributes[sqs.MessageSystemAttributeNameMessageDeduplicationId]))
+}
@@ -0,0 +1,94 @@
+// Package expvar provides a metrics.Metrics implementation publishing the htsqs metrics as expvar variables
+package expvar
+
+import (
+	"expvar"
+	"fmt"
+	"time"
+
+	"github.com/bernardopericacho/htsqs/metrics"
+)
+
+// Metrics records the htsqs activity in expvar maps. Every metric is a map keyed by its labels
+type Metrics struct {
+	receives                 *expvar.Map
+	emptyReceives            *expvar.Map
+	messagesReceived         *expvar.Map
+	acks                     *expvar.Map
+	ackFailures              *expvar.Map
+	visibilityChanges        *expvar.Map
+	visibilityChangeFailures *expvar.Map
+	handled                  *expvar.Map
+	handlerDuration          *expvar.Map
+	published                *expvar.Map
+	publishErrors            *expvar.Map
+	publishDuration          *expvar.Map
+}
+
+// New creates the metrics and publishes them as a map variable with the given name.
+// Panics if the name is already in use
+func New(name string) *Metrics {
+	root := expvar.NewMap(name)
+	metric := func(name string) *expvar.Map {
+		m := new(expvar.Map)
+		root.Set(name, m)
+		return m
+	}
+
+	return &Metrics{
+		receives:                 metric("receives"),
+		emptyReceives:            metric("empty_receives"),
+		messagesReceived:         metric("messages_received"),
+		acks:                     metric("acks"),
+		ackFailures:              metric("ack_failures"),
+		visibilityChanges:        metric("visibility_changes"),
+		visibilityChangeFailures: metric("visibility_change_failures"),
+		handled:                  metric("handled"),
+		handlerDuration:          metric("handler_duration_ns"),
+		published:                metric("published"),
+		publishErrors:            metric("publish_errors"),
+		publishDuration:          metric("publish_duration_ns"),
+	}
+}
+
+// Receive implements metrics.Metrics. Messages received are keyed by queue and consumer, "<queue>/<consumer>"
+func (m *Metrics) Receive(queue string, consumer int, messages int) {
+	m.receives.Add(queue, 1)
+	if messages == 0 {
+		m.emptyReceives.Add(queue, 1)
+	}
+	m.messagesReceived.Add(fmt.Sprintf("%s/%d", queue, consumer), int64(messages))
+}
+
+// Ack implements metrics.Metrics
+func (m *Metrics) Ack(queue string, err error) {
+	m.acks.Add(queue, 1)
+	if err != nil {
+		m.ackFailures.Add(queue, 1)
+	}
+}
+
+// VisibilityChange implements metrics.Metrics
+func (m *Metrics) VisibilityChange(queue string, err error) {
+	m.visibilityChanges.Add(queue, 1)
+	if err != nil {
+		m.visibilityChangeFailures.Add(queue, 1)
+	}
+}
+
+// Handle implements metrics.Metrics. Handled messages and their total duration are keyed
+// by queue and outcome, "<queue>/<outcome>"
+func (m *Metrics) Handle(queue string, outcome metrics.Outcome, duration time.Duration) {
+	key := fmt.Sprintf("%s/%s", queue, outcome)
+	m.handled.Add(key, 1)
+	m.handlerDuration.Add(key, int64(duration))
+}
+
+// Publish implements metrics.Metrics
+func (m *Metrics) Publish(destination string, duration time.Duration, err error) {
+	m.published.Add(destination, 1)
+	if err != nil {
+		m.publishErrors.Add(destination, 1)
+	}
+	m.publishDuration.Add(destination, int64(duration))
+}
@@ -0,0 +1,41 @@
+package expvar
+
+import (
+	"errors"
+	"expvar"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/bernardopericacho/htsqs/metrics"
+)
+
+func TestMetrics(t *testing.T) {
+	m := New("htsqs")
+
+	m.Receive("queue", 1, 0)
+	m.Receive("queue", 1, 3)
+	m.Ack("queue", nil)
+	m.Ack("queue", errors.New("ack error"))
+	m.VisibilityChange("queue", errors.New("visibility error"))
+	m.Handle("queue", metrics.OutcomeAcked, time.Second)
+	m.Publish("topic", time.Second, errors.New("publish error"))
+
+	root := expvar.Get("htsqs").(*expvar.Map)
+	value := func(metric, key string) string {
+		return root.Get(metric).(*expvar.Map).Get(key).String()
+	}
+	require.Equal(t, "2", value("receives", "queue"))
+	require.Equal(t, "1", value("empty_receives", "queue"))
+	require.Equal(t, "3", value("messages_received", "queue/1"))
+	require.Equal(t, "2", value("acks", "queue"))
+	require.Equal(t, "1", value("ack_failures", "queue"))
+	require.Equal(t, "1", value("visibility_changes", "queue"))
+	require.Equal(t, "1", value("visibility_change_failures", "queue"))
+	require.Equal(t, "1", value("handled", "queue/acked"))
+	require.Equal(t, "1000000000", value("handler_duration_ns", "queue/acked"))
+	require.Equal(t, "1", value("published", "topic"))
+	require.Equal(t, "1", value("publish_errors", "topic"))
+	require.Equal(t, "1000000000", value("publish_duration_ns", "topic"))
+}
@@ -0,0 +1,71 @@
+// Package metrics provides the interface subscribers, workers and publishers record their activity through.
+//
+// Prometheus and expvar implementations are available in their sub-packages:
+//
+//	subs := subscriber.New(subscriber.Config{SqsQueueURL: queueURL, Metrics: prometheus.New(prometheus.Config{})})
+package metrics
+
+import "time"
+
+// Outcome is the result of processing a message with a worker handler
+type Outcome string
+
+const (
+	// OutcomeAcked is recorded when the handler succeeds and the message is deleted
+	OutcomeAcked Outcome = "acked"
+
+	// OutcomeDropped is recorded when the handler returns ErrDropMessage and the message is deleted
+	OutcomeDropped Outcome = "dropped"
+
+	// OutcomeNacked is recorded when the handler fails and the message is delivered again
+	// once its visibility timeout expires
+	OutcomeNacked Outcome = "nacked"
+
+	// OutcomeRetried is recorded when the handler fails and the message is delivered again after a retry delay
+	OutcomeRetried Outcome = "retried"
+
+	// OutcomeDeadLettered is recorded when the handler fails and the message is dead-lettered
+	OutcomeDeadLettered Outcome = "dead_lettered"
+
+	// OutcomePanicked is recorded when the handler panics
+	OutcomePanicked Outcome = "panicked"
+
+	// OutcomeHandled is recorded when the message is processed by a worker MessageHandler,
+	// which acks the messages itself
+	OutcomeHandled Outcome = "handled"
+)
+
+// Metrics records the activity of subscribers, workers and publishers.
+// Implementations must be safe for concurrent use
+type Metrics interface {
+
+	// Receive records a receive request of the given subscriber consumer returning the given number of messages
+	Receive(queue string, consumer int, messages int)
+
+	// Ack records the deletion of a message, err is nil if the message was deleted
+	Ack(queue string, err error)
+
+	// VisibilityChange records the change of the visibility timeout of a message, err is nil if it was changed
+	VisibilityChange(queue string, err error)
+
+	// Handle records the processing of a message by a worker handler
+	Handle(queue string, outcome Outcome, duration time.Duration)
+
+	// Publish records the publication of a message to a queue or topic, err is nil if it was published
+	Publish(destination string, duration time.Duration, err error)
+}
+
+// Nop is the Metrics implementation recording nothing
+var Nop Metrics = nop{}
+
+type nop struct{}
+
+func (nop) Receive(string, int, int) {}
+
+func (nop) Ack(string, error) {}
+
+func (nop) VisibilityChange(string, error) {}
+
+func (nop) Handle(string, Outcome, time.Duration) {}
+
+func (nop) Publish(string, time.Duration, error) {}
@@ -0,0 +1,130 @@
+// Package prometheus provides a metrics.Metrics implementation exposing the htsqs metrics to Prometheus
+package prometheus
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/bernardopericacho/htsqs/metrics"
+)
+
+const (
+	// defaultNamespace is the namespace of the metrics
+	defaultNamespace = "htsqs"
+)
+
+// Config holds the settings of the Prometheus metrics
+type Config struct {
+
+	// Namespace of the metrics. htsqs by default
+	Namespace string
+
+	// Registerer the metrics are registered with. prometheus.DefaultRegisterer by default
+	Registerer prometheus.Registerer
+
+	// Buckets of the handler and publish duration histograms, in seconds. prometheus.DefBuckets by default
+	Buckets []float64
+}
+
+func defaultConfig(cfg *Config) {
+	if cfg.Namespace == "" {
+		cfg.Namespace = defaultNamespace
+	}
+
+	if cfg.Registerer == nil {
+		cfg.Registerer = prometheus.DefaultRegisterer
+	}
+
+	if cfg.Buckets == nil {
+		cfg.Buckets = prometheus.DefBuckets
+	}
+}
+
+// Metrics records the htsqs activity in Prometheus collectors
+type Metrics struct {
+	receives          *prometheus.CounterVec
+	messagesReceived  *prometheus.CounterVec
+	acks              *prometheus.CounterVec
+	visibilityChanges *prometheus.CounterVec
+	handlerDuration   *prometheus.HistogramVec
+	publishDuration   *prometheus.HistogramVec
+}
+
+// New creates the Prometheus metrics and registers them. Panics if they are already registered
+func New(cfg Config) *Metrics {
+	defaultConfig(&cfg)
+
+	m := &Metrics{
+		receives: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: cfg.Namespace,
+			Name:      "receives_total",
+			Help:      "Number of receive requests, by queue and whether they returned messages.",
+		}, []string{"queue", "empty"}),
+		messagesReceived: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: cfg.Namespace,
+			Name:      "messages_received_total",
+			Help:      "Number of messages received, by queue and consumer.",
+		}, []string{"queue", "consumer"}),
+		acks: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: cfg.Namespace,
+			Name:      "acks_total",
+			Help:      "Number of message deletions, by queue and result.",
+		}, []string{"queue", "result"}),
+		visibilityChanges: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: cfg.Namespace,
+			Name:      "visibility_changes_total",
+			Help:      "Number of message visibility changes, by queue and result.",
+		}, []string{"queue", "result"}),
+		handlerDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: cfg.Namespace,
+			Name:      "handler_duration_seconds",
+			Help:      "Time spent processing messages with the worker handler, by queue and outcome.",
+			Buckets:   cfg.Buckets,
+		}, []string{"queue", "outcome"}),
+		publishDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: cfg.Namespace,
+			Name:      "publish_duration_seconds",
+			Help:      "Time spent publishing messages, by destination and result.",
+			Buckets:   cfg.Buckets,
+		}, []string{"destination", "result"}),
+	}
+
+	cfg.Registerer.MustRegister(m.receives, m.messagesReceived, m.acks, m.visibilityChanges, m.handlerDuration, m.publishDuration)
+	return m
+}
+
+// Receive implements metrics.Metrics
+func (m *Metrics) Receive(queue string, consumer int, messages int) {
+	m.receives.WithLabelValues(queue, strconv.FormatBool(messages == 0)).Inc()
+	m.messagesReceived.WithLabelValues(queue, strconv.Itoa(consumer)).Add(float64(messages))
+}
+
+// Ack implements metrics.Metrics
+func (m *Metrics) Ack(queue string, err error) {
+	m.acks.WithLabelValues(queue, result(err)).Inc()
+}
+
+// VisibilityChange implements metrics.Metrics
+func (m *Metrics) VisibilityChange(queue string, err error) {
+	m.visibilityChanges.WithLabelValues(queue, result(err)).Inc()
+}
+
+// Handle implements metrics.Metrics
+func (m *Metrics) Handle(queue string, outcome metrics.Outcome, duration time.Duration) {
+	m.handlerDuration.WithLabelValues(queue, string(outcome)).Observe(duration.Seconds())
+}
+
+// Publish implements metrics.Metrics
+func (m *Metrics) Publish(destination string, duration time.Duration, err error) {
+	m.publishDuration.WithLabelValues(destination, result(err)).Observe(duration.Seconds())
+}
+
+// result returns the result label value for the given error
+func result(err error) string {
+	if err != nil {
+		return "failure"
+	}
+	return "success"
+}
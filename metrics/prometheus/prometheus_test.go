@@ -0,0 +1,42 @@
+package prometheus
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+
+	"github.com/bernardopericacho/htsqs/metrics"
+)
+
+func TestMetrics(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	m := New(Config{Registerer: registry})
+
+	m.Receive("queue", 1, 0)
+	m.Receive("queue", 1, 3)
+	m.Ack("queue", nil)
+	m.Ack("queue", errors.New("ack error"))
+	m.VisibilityChange("queue", nil)
+	m.Handle("queue", metrics.OutcomeAcked, time.Second)
+	m.Publish("topic", time.Second, errors.New("publish error"))
+
+	require.Equal(t, float64(1), testutil.ToFloat64(m.receives.WithLabelValues("queue", "true")))
+	require.Equal(t, float64(1), testutil.ToFloat64(m.receives.WithLabelValues("queue", "false")))
+	require.Equal(t, float64(3), testutil.ToFloat64(m.messagesReceived.WithLabelValues("queue", "1")))
+	require.Equal(t, float64(1), testutil.ToFloat64(m.acks.WithLabelValues("queue", "success")))
+	require.Equal(t, float64(1), testutil.ToFloat64(m.acks.WithLabelValues("queue", "failure")))
+	require.Equal(t, float64(1), testutil.ToFloat64(m.visibilityChanges.WithLabelValues("queue", "success")))
+	require.Equal(t, 1, testutil.CollectAndCount(m.handlerDuration))
+	require.Equal(t, 1, testutil.CollectAndCount(m.publishDuration))
+
+	families, err := registry.Gather()
+	require.NoError(t, err)
+	require.Len(t, families, 6)
+
+	// Metrics can not be registered twice
+	require.Panics(t, func() { New(Config{Registerer: registry}) })
+}
@@ -0,0 +1,340 @@
+// Package outbox provides a transactional outbox: messages are enqueued in a database table within the transaction
+// that changes the application state, and a relay publishes them afterwards. Messages are published if and only if
+// the transaction commits, at least once.
+//
+//	ob := outbox.New(outbox.Config{DB: db, Publisher: pub})
+//
+//	tx, _ := db.BeginTx(ctx, nil)
+//	// ... update the application state within tx
+//	if err := ob.Enqueue(ctx, tx, OrderCreated{ID: id}); err != nil {
+//		tx.Rollback()
+//	}
+//	tx.Commit()
+//
+//	go ob.Relay(ctx)
+//
+// The outbox table must be created beforehand. With SQLite:
+//
+//	CREATE TABLE outbox (
+//		id INTEGER PRIMARY KEY AUTOINCREMENT,
+//		payload TEXT NOT NULL,
+//		content_type TEXT NOT NULL,
+//		attributes TEXT NOT NULL,
+//		group_id TEXT,
+//		deduplication_id TEXT,
+//		created_at BIGINT NOT NULL,
+//		attempts INTEGER NOT NULL DEFAULT 0,
+//		next_attempt_at BIGINT NOT NULL DEFAULT 0,
+//		last_error TEXT,
+//		sent_at BIGINT
+//	)
+//
+// With PostgreSQL and MySQL, declare id as BIGSERIAL PRIMARY KEY and BIGINT AUTO_INCREMENT PRIMARY KEY respectively.
+// Times are stored as Unix milliseconds. Indexes on (sent_at, id) and (group_id, id) keep the relay queries fast.
+//
+// Only a single relay must run against a table at a time, otherwise messages are published more than once
+// and out of order.
+package outbox
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/jpillora/backoff"
+	"go.opentelemetry.io/otel/propagation"
+
+	"github.com/bernardopericacho/htsqs/codec"
+	"github.com/bernardopericacho/htsqs/publisher"
+)
+
+const (
+	// defaultTable is the name of the outbox table
+	defaultTable = "outbox"
+
+	// defaultPollInterval is the interval at which the relay polls the outbox table
+	defaultPollInterval = time.Second
+
+	// defaultBatchSize is the maximum number of messages read by every relay poll
+	defaultBatchSize = 100
+)
+
+// PlaceholderFormat is the format of the query parameter placeholders of the database
+type PlaceholderFormat int
+
+const (
+	// Question placeholders, used by SQLite and MySQL
+	Question PlaceholderFormat = iota
+
+	// Dollar placeholders, $1, $2..., used by PostgreSQL
+	Dollar
+)
+
+// Config holds the settings of the outbox and its relay
+type Config struct {
+
+	// Database holding the outbox table
+	DB *sql.DB
+
+	// Name of the outbox table. outbox by default. It is not escaped, so it must not come from user input
+	Table string
+
+	// Placeholder format of the database. Question by default
+	Placeholders PlaceholderFormat
+
+	// Publisher the relay publishes the messages through, e.g. an AWS SQS or AWS SNS publisher
+	Publisher publisher.Publisher
+
+	// Codec used to encode the messages unless the enqueuing options set one. JSON by default
+	Codec codec.Codec
+
+	// Propagator injecting the trace context of the ctx passed to Enqueue into the message attributes,
+	// so subscribers continue the trace of the transaction rather than the relay's. W3C trace context by default
+	Propagator propagation.TextMapPropagator
+
+	// Interval at which the relay polls the outbox table when there are no messages left to publish. 1 second by default
+	PollInterval time.Duration
+
+	// Maximum number of messages read by every relay poll. 100 by default
+	BatchSize int
+
+	// Delay before publishing again a message that could not be published, increased exponentially with
+	// every attempt up to MaxRetryDelay. 1 second by default
+	MinRetryDelay time.Duration
+
+	// Maximum delay before publishing again a message that could not be published. 5 minutes by default
+	MaxRetryDelay time.Duration
+
+	// Number of attempts after which the relay gives up publishing a message, leaving it in the table with its
+	// last error and relaying the messages of its group enqueued after it. Messages are retried until they are
+	// published when zero
+	MaxAttempts int
+}
+
+// Outbox enqueues messages in the outbox table and relays them to the publisher
+type Outbox struct {
+	cfg Config
+}
+
+// record is a row of the outbox table
+type record struct {
+	id              int64
+	payload         string
+	contentType     string
+	attributes      string
+	groupID         sql.NullString
+	deduplicationID sql.NullString
+	attempts        int
+}
+
+// Enqueue encodes the message with publisher.Encode and inserts it in the outbox table within the transaction.
+// The message is published by the relay once the transaction commits. The message attributes and IDs are stored
+// along with the message
+func (o *Outbox) Enqueue(ctx context.Context, tx *sql.Tx, msg interface{}, opts ...publisher.Option) error {
+	e, err := publisher.Encode(ctx, msg, publisher.NewOptions(opts...), o.cfg.Codec, o.cfg.Propagator)
+	if err != nil {
+		return err
+	}
+	attributes, err := json.Marshal(e.Attributes)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, o.query(
+		"INSERT INTO %s (payload, content_type, attributes, group_id, deduplication_id, created_at) VALUES (?, ?, ?, ?, ?, ?)"),
+		e.Body, e.Codec.ContentType(), string(attributes), nullString(e.GroupID), nullString(e.DeduplicationID), millis(time.Now()))
+	return err
+}
+
+// Relay publishes the enqueued messages until the context is done, polling the outbox table every PollInterval.
+// Returns the context error once it is done, or the first error found when querying the outbox table
+func (o *Outbox) Relay(ctx context.Context) error {
+	ticker := time.NewTicker(o.cfg.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		n, err := o.RelayOnce(ctx)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err != nil {
+			return err
+		}
+		// A full batch was published, there may be more messages waiting
+		if n == o.cfg.BatchSize {
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// RelayOnce publishes the oldest BatchSize messages ready to be published and marks them as sent.
+// Messages are published in the order they were enqueued within every message group: once a message can not be
+// published, the next messages of its group wait until it is published or the relay gives up on it. Messages
+// without group ID are not ordered, so they never wait for each other. Returns the number of published messages
+func (o *Outbox) RelayOnce(ctx context.Context) (int, error) {
+	maxAttempts := o.cfg.MaxAttempts
+	if maxAttempts <= 0 {
+		maxAttempts = math.MaxInt32
+	}
+
+	// Messages waiting for their retry delay to expire, and the messages of their groups enqueued after them,
+	// are skipped so they do not fill the batch
+	now := millis(time.Now())
+	rows, err := o.cfg.DB.QueryContext(ctx, o.query(
+		"SELECT id, payload, content_type, attributes, group_id, deduplication_id, attempts FROM %[1]s m "+
+			"WHERE sent_at IS NULL AND attempts < ? AND next_attempt_at <= ? AND NOT EXISTS ("+
+			"SELECT 1 FROM %[1]s p WHERE p.group_id = m.group_id AND p.id < m.id "+
+			"AND p.sent_at IS NULL AND p.attempts < ? AND p.next_attempt_at > ?) "+
+			"ORDER BY id LIMIT ?"), maxAttempts, now, maxAttempts, now, o.cfg.BatchSize)
+	if err != nil {
+		return 0, err
+	}
+	var records []record
+	for rows.Next() {
+		var r record
+		if err := rows.Scan(&r.id, &r.payload, &r.contentType, &r.attributes, &r.groupID, &r.deduplicationID, &r.attempts); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		records = append(records, r)
+	}
+	if err := rows.Close(); err != nil {
+		return 0, err
+	}
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	blocked := make(map[string]bool)
+	published := 0
+	for _, r := range records {
+		if r.groupID.Valid && blocked[r.groupID.String] {
+			continue
+		}
+
+		if err := o.publish(ctx, r); err != nil {
+			if r.groupID.Valid {
+				blocked[r.groupID.String] = true
+			}
+			if err := o.markFailed(ctx, r, err); err != nil {
+				return published, err
+			}
+			continue
+		}
+		if _, err := o.cfg.DB.ExecContext(ctx, o.query("UPDATE %s SET sent_at = ? WHERE id = ?"), millis(time.Now()), r.id); err != nil {
+			return published, err
+		}
+		published++
+	}
+	return published, nil
+}
+
+// DeleteSent deletes the messages published before the given time. Returns the number of deleted messages
+func (o *Outbox) DeleteSent(ctx context.Context, before time.Time) (int64, error) {
+	result, err := o.cfg.DB.ExecContext(ctx, o.query("DELETE FROM %s WHERE sent_at < ?"), millis(before))
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// publish publishes the stored message
+func (o *Outbox) publish(ctx context.Context, r record) error {
+	var attributes map[string]publisher.Attribute
+	if err := json.Unmarshal([]byte(r.attributes), &attributes); err != nil {
+		return fmt.Errorf("invalid attributes: %w", err)
+	}
+
+	opts := []publisher.Option{publisher.WithAttributes(attributes), publisher.WithCodec(codec.Verbatim(r.contentType))}
+	if r.groupID.Valid {
+		opts = append(opts, publisher.WithGroupID(r.groupID.String))
+	}
+	if r.deduplicationID.Valid {
+		opts = append(opts, publisher.WithDeduplicationID(r.deduplicationID.String))
+	}
+	return o.cfg.Publisher.Publish(ctx, []byte(r.payload), opts...)
+}
+
+// markFailed records the publishing error and schedules the next attempt
+func (o *Outbox) markFailed(ctx context.Context, r record, publishErr error) error {
+	b := backoff.Backoff{Min: o.cfg.MinRetryDelay, Max: o.cfg.MaxRetryDelay, Factor: 2}
+	next := time.Now().Add(b.ForAttempt(float64(r.attempts)))
+	_, err := o.cfg.DB.ExecContext(ctx, o.query("UPDATE %s SET attempts = attempts + 1, last_error = ?, next_attempt_at = ? WHERE id = ?"),
+		publishErr.Error(), millis(next), r.id)
+	return err
+}
+
+// query returns the query for the outbox table with the placeholders of the database
+func (o *Outbox) query(format string) string {
+	query := fmt.Sprintf(format, o.cfg.Table)
+	if o.cfg.Placeholders != Dollar {
+		return query
+	}
+
+	var sb strings.Builder
+	n := 0
+	for _, r := range query {
+		if r == '?' {
+			n++
+			fmt.Fprintf(&sb, "$%d", n)
+			continue
+		}
+		sb.WriteRune(r)
+	}
+	return sb.String()
+}
+
+func nullString(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
+}
+
+// millis returns the time as Unix milliseconds
+func millis(t time.Time) int64 {
+	return t.UnixNano() / int64(time.Millisecond)
+}
+
+func defaultOutboxConfig(cfg *Config) {
+	if cfg.Table == "" {
+		cfg.Table = defaultTable
+	}
+
+	if cfg.Codec == nil {
+		cfg.Codec = codec.JSON
+	}
+
+	if cfg.Propagator == nil {
+		cfg.Propagator = propagation.TraceContext{}
+	}
+
+	if cfg.PollInterval == 0 {
+		cfg.PollInterval = defaultPollInterval
+	}
+
+	if cfg.BatchSize == 0 {
+		cfg.BatchSize = defaultBatchSize
+	}
+
+	if cfg.MinRetryDelay == 0 {
+		cfg.MinRetryDelay = time.Second
+	}
+
+	if cfg.MaxRetryDelay == 0 {
+		cfg.MaxRetryDelay = 5 * time.Minute
+	}
+}
+
+// New creates a new outbox
+func New(cfg Config) *Outbox {
+	defaultOutboxConfig(&cfg)
+	return &Outbox{cfg: cfg}
+}
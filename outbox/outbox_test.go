@@ -0,0 +1,261 @@
+package outbox
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	_ "modernc.org/sqlite"
+	"github.com/stretchr/testify/require"
+
+	"github.com/bernardopericacho/htsqs/codec"
+	"github.com/bernardopericacho/htsqs/memory"
+	"github.com/bernardopericacho/htsqs/publisher"
+	sqspublisher "github.com/bernardopericacho/htsqs/publisher/sqs"
+)
+
+const schema = `CREATE TABLE outbox (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	payload TEXT NOT NULL,
+	content_type TEXT NOT NULL,
+	attributes TEXT NOT NULL,
+	group_id TEXT,
+	deduplication_id TEXT,
+	created_at BIGINT NOT NULL,
+	attempts INTEGER NOT NULL DEFAULT 0,
+	next_attempt_at BIGINT NOT NULL DEFAULT 0,
+	last_error TEXT,
+	sent_at BIGINT
+)`
+
+type orderCreated struct {
+	ID    string `json:"id"`
+	Group string `json:"-"`
+}
+
+func (orderCreated) MessageType() string {
+	return "order.created"
+}
+
+func (m orderCreated) MessageGroupID() string {
+	return m.Group
+}
+
+type published struct {
+	body    string
+	options *publisher.Options
+}
+
+// publisherMock records the published messages, failing the ones whose body is in failures
+type publisherMock struct {
+	mu        sync.Mutex
+	published []published
+	failures  map[string]int
+}
+
+func (p *publisherMock) Publish(ctx context.Context, msg interface{}, opts ...publisher.Option) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	body := string(msg.([]byte))
+	if p.failures[body] > 0 {
+		p.failures[body]--
+		return errors.New("publishing error")
+	}
+	p.published = append(p.published, published{body: body, options: publisher.NewOptions(opts...)})
+	return nil
+}
+
+func (p *publisherMock) bodies() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	bodies := make([]string, 0, len(p.published))
+	for _, m := range p.published {
+		bodies = append(bodies, m.body)
+	}
+	return bodies
+}
+
+func newDB(t *testing.T) *sql.DB {
+	db, err := sql.Open("sqlite", ":memory:")
+	require.NoError(t, err)
+	// Every connection to an in-memory database opens a different database
+	db.SetMaxOpenConns(1)
+	_, err = db.Exec(schema)
+	require.NoError(t, err)
+	return db
+}
+
+func enqueue(t *testing.T, db *sql.DB, o *Outbox, msg interface{}, opts ...publisher.Option) {
+	tx, err := db.Begin()
+	require.NoError(t, err)
+	require.NoError(t, o.Enqueue(context.TODO(), tx, msg, opts...))
+	require.NoError(t, tx.Commit())
+}
+
+func TestEnqueue(t *testing.T) {
+	db := newDB(t)
+	defer db.Close()
+	pub := &publisherMock{}
+	o := New(Config{DB: db, Publisher: pub})
+
+	// Messages enqueued within a transaction that is rolled back are never published
+	tx, err := db.Begin()
+	require.NoError(t, err)
+	require.NoError(t, o.Enqueue(context.TODO(), tx, orderCreated{ID: "0"}))
+	require.NoError(t, tx.Rollback())
+
+	enqueue(t, db, o, orderCreated{ID: "1", Group: "group"}, publisher.WithAttribute("source", publisher.StringAttribute("test")), publisher.WithDeduplicationID("dedup"))
+	enqueue(t, db, o, []byte{0, 1, 2}, publisher.WithCodec(codec.Raw))
+
+	n, err := o.RelayOnce(context.TODO())
+	require.NoError(t, err)
+	require.Equal(t, 2, n)
+	require.Equal(t, []string{`{"id":"1"}`, "AAEC"}, pub.bodies())
+
+	first := pub.published[0].options
+	require.Equal(t, map[string]publisher.Attribute{
+		"source":                publisher.StringAttribute("test"),
+		publisher.TypeAttribute: publisher.StringAttribute("order.created"),
+	}, first.Attributes)
+	require.Equal(t, "group", first.GroupID)
+	require.Equal(t, "dedup", first.DeduplicationID)
+	require.Equal(t, "application/json", first.Codec.ContentType())
+	require.Equal(t, "application/octet-stream", pub.published[1].options.Codec.ContentType())
+
+	// Published messages are not published again
+	n, err = o.RelayOnce(context.TODO())
+	require.NoError(t, err)
+	require.Equal(t, 0, n)
+
+	deleted, err := o.DeleteSent(context.TODO(), time.Now().Add(time.Second))
+	require.NoError(t, err)
+	require.Equal(t, int64(2), deleted)
+}
+
+func TestRelayOnceOrdering(t *testing.T) {
+	db := newDB(t)
+	defer db.Close()
+	pub := &publisherMock{failures: map[string]int{`{"id":"a1"}`: 1}}
+	o := New(Config{DB: db, Publisher: pub, MinRetryDelay: 20 * time.Millisecond})
+
+	enqueue(t, db, o, orderCreated{ID: "a1", Group: "a"})
+	enqueue(t, db, o, orderCreated{ID: "b1", Group: "b"})
+	enqueue(t, db, o, orderCreated{ID: "a2", Group: "a"})
+
+	// The messages enqueued after a failed message of the same group wait for it
+	n, err := o.RelayOnce(context.TODO())
+	require.NoError(t, err)
+	require.Equal(t, 1, n)
+	require.Equal(t, []string{`{"id":"b1"}`}, pub.bodies())
+
+	var attempts int
+	var lastError string
+	require.NoError(t, db.QueryRow("SELECT attempts, last_error FROM outbox WHERE id = 1").Scan(&attempts, &lastError))
+	require.Equal(t, 1, attempts)
+	require.Equal(t, "publishing error", lastError)
+
+	// The failed message is not retried before the retry delay expires
+	n, err = o.RelayOnce(context.TODO())
+	require.NoError(t, err)
+	require.Equal(t, 0, n)
+
+	time.Sleep(30 * time.Millisecond)
+	n, err = o.RelayOnce(context.TODO())
+	require.NoError(t, err)
+	require.Equal(t, 2, n)
+	require.Equal(t, []string{`{"id":"b1"}`, `{"id":"a1"}`, `{"id":"a2"}`}, pub.bodies())
+}
+
+func TestRelayOnceMaxAttempts(t *testing.T) {
+	db := newDB(t)
+	defer db.Close()
+	pub := &publisherMock{failures: map[string]int{`{"id":"1"}`: 10}}
+	o := New(Config{DB: db, Publisher: pub, MinRetryDelay: time.Millisecond, MaxRetryDelay: time.Millisecond, MaxAttempts: 2})
+
+	enqueue(t, db, o, orderCreated{ID: "1", Group: "group"})
+	enqueue(t, db, o, orderCreated{ID: "2", Group: "group"})
+
+	for i := 0; i < 2; i++ {
+		n, err := o.RelayOnce(context.TODO())
+		require.NoError(t, err)
+		require.Equal(t, 0, n)
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	// The relay gives up on the failed message and publishes the next one
+	n, err := o.RelayOnce(context.TODO())
+	require.NoError(t, err)
+	require.Equal(t, 1, n)
+	require.Equal(t, []string{`{"id":"2"}`}, pub.bodies())
+}
+
+func TestRelayOnceBlockedGroup(t *testing.T) {
+	db := newDB(t)
+	defer db.Close()
+	pub := &publisherMock{failures: map[string]int{`{"id":"a1"}`: 10, `{"id":"u1"}`: 10}}
+	o := New(Config{DB: db, Publisher: pub, BatchSize: 3, MinRetryDelay: time.Minute})
+
+	// A batch worth of messages of a failing group is enqueued before the healthy ones
+	enqueue(t, db, o, orderCreated{ID: "a1", Group: "a"})
+	enqueue(t, db, o, orderCreated{ID: "a2", Group: "a"})
+	enqueue(t, db, o, orderCreated{ID: "a3", Group: "a"})
+	enqueue(t, db, o, orderCreated{ID: "u1"})
+	enqueue(t, db, o, orderCreated{ID: "u2"})
+	enqueue(t, db, o, orderCreated{ID: "b1", Group: "b"})
+
+	n, err := o.RelayOnce(context.TODO())
+	require.NoError(t, err)
+	require.Equal(t, 0, n)
+
+	// The messages waiting for the failed one of their group are skipped, and messages without group ID
+	// do not wait for each other
+	n, err = o.RelayOnce(context.TODO())
+	require.NoError(t, err)
+	require.Equal(t, 2, n)
+	require.Equal(t, []string{`{"id":"u2"}`, `{"id":"b1"}`}, pub.bodies())
+}
+
+func TestRelay(t *testing.T) {
+	db := newDB(t)
+	defer db.Close()
+	broker := memory.NewBroker()
+	queueURL := broker.CreateQueue("queue", memory.QueueConfig{})
+	pub := sqspublisher.New(sqspublisher.Config{SQSClient: broker, QueueURL: queueURL})
+	o := New(Config{DB: db, Publisher: pub, PollInterval: 10 * time.Millisecond, BatchSize: 1})
+
+	ctx, cancel := context.WithCancel(context.TODO())
+	errCh := make(chan error, 1)
+	go func() { errCh <- o.Relay(ctx) }()
+
+	enqueue(t, db, o, orderCreated{ID: "1"})
+	enqueue(t, db, o, []byte("message"), publisher.WithCodec(codec.Raw))
+	require.Eventually(t, func() bool { return broker.Len(queueURL) == 2 }, time.Second, time.Millisecond)
+	cancel()
+	require.Equal(t, context.Canceled, <-errCh)
+
+	output, err := broker.ReceiveMessageWithContext(context.TODO(), &sqs.ReceiveMessageInput{
+		QueueUrl:              aws.String(queueURL),
+		MaxNumberOfMessages:   aws.Int64(10),
+		MessageAttributeNames: []*string{aws.String("All")},
+	})
+	require.NoError(t, err)
+	require.Len(t, output.Messages, 2)
+	require.Equal(t, `{"id":"1"}`, aws.StringValue(output.Messages[0].Body))
+	require.Equal(t, "order.created", aws.StringValue(output.Messages[0].MessageAttributes[publisher.TypeAttribute].StringValue))
+	require.NotContains(t, output.Messages[0].MessageAttributes, codec.ContentTypeAttribute)
+	require.Equal(t, "bWVzc2FnZQ==", aws.StringValue(output.Messages[1].Body))
+	require.Equal(t, "application/octet-stream", aws.StringValue(output.Messages[1].MessageAttributes[codec.ContentTypeAttribute].StringValue))
+}
+
+func TestQueryPlaceholders(t *testing.T) {
+	o := New(Config{Table: "events", Placeholders: Dollar})
+	require.Equal(t, "UPDATE events SET sent_at = $1 WHERE id = $2", o.query("UPDATE %s SET sent_at = ? WHERE id = ?"))
+
+	o = New(Config{})
+	require.Equal(t, "UPDATE outbox SET sent_at = ? WHERE id = ?", o.query("UPDATE %s SET sent_at = ? WHERE id = ?"))
+}
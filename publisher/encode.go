@@ -0,0 +1,102 @@
+package publisher
+
+import (
+	"context"
+	"encoding/base64"
+
+	"go.opentelemetry.io/otel/propagation"
+
+	"github.com/bernardopericacho/htsqs/codec"
+)
+
+// Encoded is a message encoded with its codec, along with the attributes and the IDs it is published with
+type Encoded struct {
+
+	// Message body, base64 encoded when the codec is binary
+	Body string
+
+	// Codec the message is encoded with
+	Codec codec.Codec
+
+	// Message attributes, including the ones set through the publishing options
+	Attributes map[string]Attribute
+
+	// Message group ID set through the publishing options or provided by the message. Empty if there is none
+	GroupID string
+
+	// Deduplication ID set through the publishing options or provided by the message. Empty if there is none
+	DeduplicationID string
+}
+
+// Encode encodes msg with the codec set through the publishing options, or the given codec otherwise.
+// Binary encodings are base64 encoded and the content-type attribute is set for any codec but JSON.
+// The type of the messages implementing TypedMessage is set unless the publishing options set it.
+// The trace context of ctx, if any, is injected into the message attributes when the propagator is not nil
+func Encode(ctx context.Context, msg interface{}, o *Options, c codec.Codec, propagator propagation.TextMapPropagator) (*Encoded, error) {
+	if o.Codec != nil {
+		c = o.Codec
+	}
+
+	b, err := c.Marshal(msg)
+	if err != nil {
+		return nil, err
+	}
+
+	e := &Encoded{Body: string(b), Codec: c, Attributes: make(map[string]Attribute, len(o.Attributes)+1)}
+	if c.Binary() {
+		e.Body = base64.StdEncoding.EncodeToString(b)
+	}
+
+	for name, attr := range o.Attributes {
+		e.Attributes[name] = attr
+	}
+	if tm, ok := msg.(TypedMessage); ok {
+		if _, set := e.Attributes[TypeAttribute]; !set {
+			e.Attributes[TypeAttribute] = StringAttribute(tm.MessageType())
+		}
+	}
+	if c.ContentType() != codec.JSON.ContentType() {
+		e.Attributes[codec.ContentTypeAttribute] = StringAttribute(c.ContentType())
+	}
+	if propagator != nil {
+		propagator.Inject(ctx, carrier(e.Attributes))
+	}
+
+	e.GroupID, e.DeduplicationID = MessageIDs(msg, o)
+	return e, nil
+}
+
+// MessageIDs returns the message group ID and the deduplication ID of the message. The IDs set through
+// the publishing options take precedence over the ones provided by the messages implementing GroupedMessage
+// or DeduplicatedMessage. Empty when there are none
+func MessageIDs(msg interface{}, o *Options) (groupID string, deduplicationID string) {
+	groupID = o.GroupID
+	if gm, ok := msg.(GroupedMessage); ok && groupID == "" {
+		groupID = gm.MessageGroupID()
+	}
+
+	deduplicationID = o.DeduplicationID
+	if dm, ok := msg.(DeduplicatedMessage); ok && deduplicationID == "" {
+		deduplicationID = dm.MessageDeduplicationID()
+	}
+	return groupID, deduplicationID
+}
+
+// carrier injects the trace context into the message attributes as String attributes
+type carrier map[string]Attribute
+
+func (c carrier) Get(key string) string {
+	return c[key].StringValue
+}
+
+func (c carrier) Set(key, value string) {
+	c[key] = StringAttribute(value)
+}
+
+func (c carrier) Keys() []string {
+	keys := make([]string, 0, len(c))
+	for key := range c {
+		keys = append(keys, key)
+	}
+	return keys
+}
@@ -0,0 +1,47 @@
+package publisher
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/bernardopericacho/htsqs/codec"
+)
+
+type orderCreated struct {
+	ID string `json:"id"`
+}
+
+func (orderCreated) MessageType() string {
+	return "order.created"
+}
+
+func (orderCreated) MessageGroupID() string {
+	return "group"
+}
+
+func (orderCreated) MessageDeduplicationID() string {
+	return "deduplication"
+}
+
+func TestEncode(t *testing.T) {
+	e, err := Encode(context.TODO(), orderCreated{ID: "1"}, NewOptions(WithAttribute("event", StringAttribute("created"))), codec.JSON, nil)
+	require.NoError(t, err)
+	require.Equal(t, `{"id":"1"}`, e.Body)
+	require.Equal(t, codec.JSON, e.Codec)
+	require.Equal(t, map[string]Attribute{
+		"event":       StringAttribute("created"),
+		TypeAttribute: StringAttribute("order.created"),
+	}, e.Attributes)
+	require.Equal(t, "group", e.GroupID)
+	require.Equal(t, "deduplication", e.DeduplicationID)
+
+	// The publishing options take precedence over the codec and the IDs provided by the message
+	e, err = Encode(context.TODO(), []byte{0, 1, 2}, NewOptions(WithCodec(codec.Raw), WithGroupID("other")), codec.JSON, nil)
+	require.NoError(t, err)
+	require.Equal(t, "AAEC", e.Body)
+	require.Equal(t, map[string]Attribute{codec.ContentTypeAttribute: StringAttribute("application/octet-stream")}, e.Attributes)
+	require.Equal(t, "other", e.GroupID)
+	require.Empty(t, e.DeduplicationID)
+}
@@ -0,0 +1,41 @@
+// Package batch provides the helpers shared by the publishers to group messages into AWS batch requests.
+package batch
+
+const (
+	// MaxEntries is the maximum number of messages allowed in a single AWS SQS/SNS batch request
+	MaxEntries = 10
+
+	// MaxPayloadSize is the maximum size in bytes of a single message and of the sum of all the
+	// messages sent in a single AWS SQS/SNS batch request
+	MaxPayloadSize = 256 * 1024
+)
+
+// Split groups the messages, represented by their size in bytes, into chunks that fit in a single batch request.
+// Every chunk contains the indexes of at most MaxEntries messages whose sizes sum up to at most MaxPayloadSize.
+// Messages are kept in their original order. Messages with a negative size or bigger than MaxPayloadSize
+// can not be sent and are not included in any chunk.
+func Split(sizes []int) [][]int {
+	var chunks [][]int
+	var current []int
+	var currentSize int
+
+	for i, size := range sizes {
+		if size < 0 || size > MaxPayloadSize {
+			continue
+		}
+
+		if len(current) == MaxEntries || currentSize+size > MaxPayloadSize {
+			chunks = append(chunks, current)
+			current, currentSize = nil, 0
+		}
+
+		current = append(current, i)
+		currentSize += size
+	}
+
+	if len(current) > 0 {
+		chunks = append(chunks, current)
+	}
+
+	return chunks
+}
@@ -0,0 +1,43 @@
+package batch
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSplit(t *testing.T) {
+
+	tt := []struct {
+		name     string
+		sizes    []int
+		expected [][]int
+	}{
+		{
+			"No messages",
+			nil,
+			nil,
+		},
+		{
+			"Split by number of entries",
+			[]int{1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1},
+			[][]int{{0, 1, 2, 3, 4, 5, 6, 7, 8, 9}, {10, 11}},
+		},
+		{
+			"Split by payload size",
+			[]int{MaxPayloadSize / 2, MaxPayloadSize / 2, 1, MaxPayloadSize},
+			[][]int{{0, 1}, {2}, {3}},
+		},
+		{
+			"Skip messages that can not be sent",
+			[]int{1, -1, MaxPayloadSize + 1, 1},
+			[][]int{{0, 3}},
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			require.Equal(t, tc.expected, Split(tc.sizes))
+		})
+	}
+}
@@ -0,0 +1,43 @@
+// Package fifo provides the helpers shared by the publishers to publish messages to FIFO queues and topics.
+package fifo
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+
+	"github.com/bernardopericacho/htsqs/publisher"
+)
+
+// suffix is the suffix of the names of the AWS SQS FIFO queues and AWS SNS FIFO topics
+const suffix = ".fifo"
+
+// IsFIFO reports whether the given queue URL or topic ARN belongs to a FIFO queue or topic
+func IsFIFO(name string) bool {
+	return strings.HasSuffix(name, suffix)
+}
+
+// IDs returns the message group ID and the deduplication ID of a message published to the given queue URL
+// or topic ARN. Both IDs are nil when the queue or topic is not FIFO. The IDs set through the publishing options
+// take precedence over the ones provided by the message. When contentBased is set and no deduplication ID is
+// provided, the deduplication ID is generated from the SHA-256 hash of the message body
+func IDs(name string, msg interface{}, body []byte, o *publisher.Options, contentBased bool) (groupID *string, deduplicationID *string, err error) {
+	if !IsFIFO(name) {
+		return nil, nil, nil
+	}
+
+	group, deduplication := publisher.MessageIDs(msg, o)
+	if group == "" {
+		return nil, nil, publisher.ErrMissingGroupID
+	}
+
+	if deduplication == "" && contentBased {
+		hash := sha256.Sum256(body)
+		deduplication = hex.EncodeToString(hash[:])
+	}
+	if deduplication == "" {
+		return &group, nil, nil
+	}
+
+	return &group, &deduplication, nil
+}
@@ -0,0 +1,101 @@
+package fifo
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/bernardopericacho/htsqs/publisher"
+)
+
+type jsonString string
+
+func (js jsonString) MarshalJSON() ([]byte, error) {
+	return []byte(js), nil
+}
+
+type groupedMessage struct {
+	jsonString
+}
+
+func (m groupedMessage) MessageGroupID() string {
+	return "messageGroup"
+}
+
+func (m groupedMessage) MessageDeduplicationID() string {
+	return "messageDeduplication"
+}
+
+func TestIDs(t *testing.T) {
+
+	tt := []struct {
+		name                    string
+		queue                   string
+		msg                     groupedMessage
+		opts                    []publisher.Option
+		contentBased            bool
+		expectedGroupID         *string
+		expectedDeduplicationID *string
+		expectedErr             error
+	}{
+		{
+			"Standard queue",
+			"myQueue",
+			groupedMessage{`"message"`},
+			nil,
+			true,
+			nil,
+			nil,
+			nil,
+		},
+		{
+			"IDs provided by the message",
+			"myQueue.fifo",
+			groupedMessage{`"message"`},
+			nil,
+			false,
+			strPtr("messageGroup"),
+			strPtr("messageDeduplication"),
+			nil,
+		},
+		{
+			"IDs provided by the options",
+			"myQueue.fifo",
+			groupedMessage{`"message"`},
+			[]publisher.Option{publisher.WithGroupID("optionsGroup"), publisher.WithDeduplicationID("optionsDeduplication")},
+			false,
+			strPtr("optionsGroup"),
+			strPtr("optionsDeduplication"),
+			nil,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			groupID, deduplicationID, err := IDs(tc.queue, tc.msg, []byte(tc.msg.jsonString), publisher.NewOptions(tc.opts...), tc.contentBased)
+			require.Equal(t, tc.expectedErr, err)
+			require.Equal(t, tc.expectedGroupID, groupID)
+			require.Equal(t, tc.expectedDeduplicationID, deduplicationID)
+		})
+	}
+}
+
+func TestIDsContentBased(t *testing.T) {
+	msg := jsonString(`"message"`)
+
+	_, _, err := IDs("myQueue.fifo", msg, []byte(msg), publisher.NewOptions(), true)
+	require.Equal(t, publisher.ErrMissingGroupID, err)
+
+	groupID, deduplicationID, err := IDs("myQueue.fifo", msg, []byte(msg), publisher.NewOptions(publisher.WithGroupID("group")), false)
+	require.NoError(t, err)
+	require.Equal(t, "group", *groupID)
+	require.Nil(t, deduplicationID)
+
+	_, deduplicationID, err = IDs("myQueue.fifo", msg, []byte(msg), publisher.NewOptions(publisher.WithGroupID("group")), true)
+	require.NoError(t, err)
+	require.Equal(t, "fd3830a0864dd9dfe2cf351f669ec8a152d4065b8b08d51c2ccf7d780e130543", *deduplicationID)
+}
+
+func strPtr(s string) *string {
+	return &s
+}
@@ -0,0 +1,180 @@
+// Package message provides the encoding of the messages shared by the publishers.
+package message
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+
+	"go.opentelemetry.io/otel/propagation"
+
+	"github.com/bernardopericacho/htsqs/blobstore"
+	"github.com/bernardopericacho/htsqs/codec"
+	"github.com/bernardopericacho/htsqs/compression"
+	"github.com/bernardopericacho/htsqs/encryption"
+	"github.com/bernardopericacho/htsqs/publisher"
+	"github.com/bernardopericacho/htsqs/publisher/internal/batch"
+	"github.com/bernardopericacho/htsqs/publisher/internal/fifo"
+)
+
+const (
+	// defaultCompressionThreshold is the body size above which the messages are compressed unless set otherwise
+	defaultCompressionThreshold = 1024
+)
+
+// Config holds the publisher settings used to encode messages
+type Config struct {
+
+	// Queue URL or topic ARN the messages are published to
+	Destination string
+
+	// Codec used when the publishing options do not set one
+	Codec codec.Codec
+
+	// Generate the deduplication ID of the messages published to FIFO queues and topics from their body
+	ContentBasedDeduplication bool
+
+	// Propagator injecting the trace context into the message attributes. The trace context is not injected when nil
+	Propagator propagation.TextMapPropagator
+
+	// Compressor compressing the bodies larger than CompressionThreshold. Messages are not compressed when nil
+	Compressor compression.Compressor
+
+	// Body size above which the messages are compressed. 1 KB when zero
+	CompressionThreshold int
+
+	// Provider of the data keys the bodies are encrypted under. Messages are not encrypted when nil
+	KeyProvider encryption.KeyProvider
+
+	// Store the messages larger than OffloadThreshold are offloaded to. Messages are not offloaded when nil
+	BlobStore blobstore.BlobStore
+
+	// Size above which the messages are offloaded to the BlobStore. The maximum size allowed by AWS when zero
+	OffloadThreshold int
+}
+
+// Message is an encoded message ready to be published
+type Message struct {
+
+	// Message body
+	Body string
+
+	// Message attributes, including the ones set through the publishing options
+	Attributes map[string]publisher.Attribute
+
+	// Message group ID, nil unless published to a FIFO queue or topic
+	GroupID *string
+
+	// Deduplication ID, nil unless published to a FIFO queue or topic
+	DeduplicationID *string
+}
+
+// Size returns the number of bytes the message counts towards the AWS message size limit
+func (m *Message) Size() int {
+	size := len(m.Body)
+	for name, attr := range m.Attributes {
+		size += len(name) + attr.Size()
+	}
+	return size
+}
+
+// Encode encodes msg with publisher.Encode, using the publisher codec unless the publishing options set one.
+// Bodies exceeding the compression threshold are compressed, base64 encoded and the content-encoding attribute is set.
+// Bodies are then encrypted and base64 encoded when a key provider is set, adding the encrypted data key attributes.
+// Messages exceeding the offload threshold are stored in the BlobStore, if any, and replaced with a pointer.
+// Returns publisher.ErrMessageTooLarge if the message exceeds the maximum size allowed by AWS
+func Encode(ctx context.Context, msg interface{}, o *publisher.Options, cfg Config) (*Message, error) {
+	e, err := publisher.Encode(ctx, msg, o, cfg.Codec, cfg.Propagator)
+	if err != nil {
+		return nil, err
+	}
+
+	m := &Message{Body: e.Body, Attributes: e.Attributes}
+	m.GroupID, m.DeduplicationID, err = fifo.IDs(cfg.Destination, msg, []byte(m.Body), o, cfg.ContentBasedDeduplication)
+	if err != nil {
+		return nil, err
+	}
+
+	if cfg.Compressor != nil {
+		if err := compress(m, cfg.Compressor, cfg.CompressionThreshold); err != nil {
+			return nil, err
+		}
+	}
+
+	if cfg.KeyProvider != nil {
+		if err := encrypt(ctx, m, cfg.KeyProvider); err != nil {
+			return nil, err
+		}
+	}
+
+	threshold := cfg.OffloadThreshold
+	if threshold == 0 {
+		threshold = batch.MaxPayloadSize
+	}
+	if cfg.BlobStore != nil && m.Size() > threshold {
+		if err := offload(ctx, m, cfg.BlobStore); err != nil {
+			return nil, err
+		}
+	}
+
+	if m.Size() > batch.MaxPayloadSize {
+		return nil, publisher.ErrMessageTooLarge
+	}
+
+	return m, nil
+}
+
+// compress replaces the message body with its base64 encoded compression when the body is larger than the threshold.
+// The body is left as it is when compressing does not make it smaller
+func compress(m *Message, c compression.Compressor, threshold int) error {
+	if threshold == 0 {
+		threshold = defaultCompressionThreshold
+	}
+	if len(m.Body) <= threshold {
+		return nil
+	}
+
+	b, err := c.Compress([]byte(m.Body))
+	if err != nil {
+		return fmt.Errorf("error when compressing the message body: %w", err)
+	}
+	body := base64.StdEncoding.EncodeToString(b)
+	if len(body) >= len(m.Body) {
+		return nil
+	}
+	m.Body = body
+	m.Attributes[compression.ContentEncodingAttribute] = publisher.StringAttribute(c.Encoding())
+	return nil
+}
+
+// encrypt replaces the message body with its base64 encoded encryption under a new data key.
+// The encrypted data key and the ID of the master key it is encrypted under are added to the attributes
+func encrypt(ctx context.Context, m *Message, p encryption.KeyProvider) error {
+	b, key, err := encryption.Encrypt(ctx, p, []byte(m.Body))
+	if err != nil {
+		return fmt.Errorf("error when encrypting the message body: %w", err)
+	}
+	m.Body = base64.StdEncoding.EncodeToString(b)
+	m.Attributes[encryption.EncryptedKeyAttribute] = publisher.BinaryAttribute(key.Encrypted)
+	m.Attributes[encryption.KeyIDAttribute] = publisher.StringAttribute(key.KeyID)
+	return nil
+}
+
+// offload stores the message body in the store under a random key and replaces it with a pointer to it.
+// The payload size attribute marks the message as offloaded
+func offload(ctx context.Context, m *Message, store blobstore.BlobStore) error {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return err
+	}
+	key := hex.EncodeToString(b)
+
+	if err := store.Put(ctx, key, []byte(m.Body)); err != nil {
+		return fmt.Errorf("error when offloading the message payload: %w", err)
+	}
+	m.Attributes[blobstore.PayloadSizeAttribute] = publisher.NumberAttribute(float64(len(m.Body)))
+	m.Body = blobstore.Pointer{Key: key}.Marshal()
+	return nil
+}
@@ -0,0 +1,236 @@
+package message
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/bernardopericacho/htsqs/blobstore"
+	"github.com/bernardopericacho/htsqs/codec"
+	"github.com/bernardopericacho/htsqs/compression"
+	"github.com/bernardopericacho/htsqs/encryption"
+	"github.com/bernardopericacho/htsqs/publisher"
+)
+
+type orderCreated struct {
+	ID string
+}
+
+func (orderCreated) MessageType() string {
+	return "order.created"
+}
+
+func TestEncode(t *testing.T) {
+
+	tt := []struct {
+		name        string
+		msg         interface{}
+		opts        []publisher.Option
+		cfg         Config
+		expected    *Message
+		expectedErr error
+	}{
+		{
+			"JSON",
+			map[string]string{"msg": "message"},
+			[]publisher.Option{publisher.WithAttribute("event", publisher.StringAttribute("created"))},
+			Config{Codec: codec.JSON},
+			&Message{Body: `{"msg":"message"}`, Attributes: map[string]publisher.Attribute{"event": publisher.StringAttribute("created")}},
+			nil,
+		},
+		{
+			"Binary codec",
+			[]byte{0, 1, 2},
+			nil,
+			Config{Codec: codec.Raw},
+			&Message{Body: "AAEC", Attributes: map[string]publisher.Attribute{codec.ContentTypeAttribute: publisher.StringAttribute("application/octet-stream")}},
+			nil,
+		},
+		{
+			"Options codec",
+			"message",
+			[]publisher.Option{publisher.WithCodec(codec.JSON)},
+			Config{Codec: codec.Raw},
+			&Message{Body: `"message"`, Attributes: map[string]publisher.Attribute{}},
+			nil,
+		},
+		{
+			"FIFO",
+			"message",
+			[]publisher.Option{publisher.WithGroupID("group"), publisher.WithDeduplicationID("id")},
+			Config{Destination: "queue.fifo", Codec: codec.JSON},
+			&Message{Body: `"message"`, Attributes: map[string]publisher.Attribute{}, GroupID: stringPtr("group"), DeduplicationID: stringPtr("id")},
+			nil,
+		},
+		{
+			"Typed message",
+			orderCreated{ID: "1"},
+			nil,
+			Config{Codec: codec.JSON},
+			&Message{Body: `{"ID":"1"}`, Attributes: map[string]publisher.Attribute{publisher.TypeAttribute: publisher.StringAttribute("order.created")}},
+			nil,
+		},
+		{
+			"Options type",
+			orderCreated{ID: "1"},
+			[]publisher.Option{publisher.WithAttribute(publisher.TypeAttribute, publisher.StringAttribute("order.updated"))},
+			Config{Codec: codec.JSON},
+			&Message{Body: `{"ID":"1"}`, Attributes: map[string]publisher.Attribute{publisher.TypeAttribute: publisher.StringAttribute("order.updated")}},
+			nil,
+		},
+		{
+			"Unsupported type",
+			1,
+			nil,
+			Config{Codec: codec.Raw},
+			nil,
+			codec.ErrUnsupportedType,
+		},
+		{
+			"Too large",
+			strings.Repeat("a", 256*1024),
+			nil,
+			Config{Codec: codec.JSON},
+			nil,
+			publisher.ErrMessageTooLarge,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			m, err := Encode(context.TODO(), tc.msg, publisher.NewOptions(tc.opts...), tc.cfg)
+			require.Equal(t, tc.expectedErr, err)
+			require.Equal(t, tc.expected, m)
+		})
+	}
+}
+
+func TestEncodeTraceContext(t *testing.T) {
+	traceID, _ := trace.TraceIDFromHex("4bf92f3577b34da6a3ce929d0e0e4736")
+	spanID, _ := trace.SpanIDFromHex("00f067aa0ba902b7")
+	ctx := trace.ContextWithRemoteSpanContext(context.TODO(), trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID:    traceID,
+		SpanID:     spanID,
+		TraceFlags: trace.FlagsSampled,
+	}))
+
+	m, err := Encode(ctx, "message", publisher.NewOptions(), Config{Codec: codec.JSON, Propagator: propagation.TraceContext{}})
+	require.NoError(t, err)
+	require.Equal(t, publisher.StringAttribute("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"), m.Attributes["traceparent"])
+
+	// Messages published outside a trace have no trace context
+	m, err = Encode(context.TODO(), "message", publisher.NewOptions(), Config{Codec: codec.JSON, Propagator: propagation.TraceContext{}})
+	require.NoError(t, err)
+	require.Empty(t, m.Attributes)
+}
+
+// blobStoreMock keeps the blobs in memory
+type blobStoreMock struct {
+	blobs map[string][]byte
+	err   error
+}
+
+func (s *blobStoreMock) Put(ctx context.Context, key string, data []byte) error {
+	if s.err != nil {
+		return s.err
+	}
+	s.blobs[key] = data
+	return nil
+}
+
+func (s *blobStoreMock) Get(ctx context.Context, key string) ([]byte, error) {
+	return s.blobs[key], nil
+}
+
+func (s *blobStoreMock) Delete(ctx context.Context, key string) error {
+	delete(s.blobs, key)
+	return nil
+}
+
+func TestEncodeCompression(t *testing.T) {
+	large := strings.Repeat("message", 200)
+
+	m, err := Encode(context.TODO(), large, publisher.NewOptions(), Config{Codec: codec.JSON, Compressor: compression.Gzip})
+	require.NoError(t, err)
+	require.Equal(t, publisher.StringAttribute("gzip"), m.Attributes[compression.ContentEncodingAttribute])
+	b, err := base64.StdEncoding.DecodeString(m.Body)
+	require.NoError(t, err)
+	body, err := compression.Gzip.Decompress(b)
+	require.NoError(t, err)
+	require.Equal(t, `"`+large+`"`, string(body))
+
+	// Bodies below the threshold are not compressed
+	m, err = Encode(context.TODO(), large, publisher.NewOptions(), Config{Codec: codec.JSON, Compressor: compression.Gzip, CompressionThreshold: 2048})
+	require.NoError(t, err)
+	require.Equal(t, `"`+large+`"`, m.Body)
+	require.Empty(t, m.Attributes)
+
+	// Bodies that do not get smaller are not compressed
+	random := make([]byte, 2048)
+	_, _ = rand.Read(random)
+	m, err = Encode(context.TODO(), random, publisher.NewOptions(), Config{Codec: codec.Raw, Compressor: compression.Gzip})
+	require.NoError(t, err)
+	require.Equal(t, base64.StdEncoding.EncodeToString(random), m.Body)
+	require.NotContains(t, m.Attributes, compression.ContentEncodingAttribute)
+}
+
+func TestEncodeEncryption(t *testing.T) {
+	provider, err := encryption.NewStaticKeyProvider("key", map[string][]byte{"key": bytes.Repeat([]byte{1}, 32)})
+	require.NoError(t, err)
+	large := strings.Repeat("message", 200)
+
+	m, err := Encode(context.TODO(), large, publisher.NewOptions(), Config{Codec: codec.JSON, Compressor: compression.Gzip, KeyProvider: provider})
+	require.NoError(t, err)
+	require.Equal(t, publisher.StringAttribute("key"), m.Attributes[encryption.KeyIDAttribute])
+	require.Equal(t, publisher.StringAttribute("gzip"), m.Attributes[compression.ContentEncodingAttribute])
+
+	// Bodies are compressed before being encrypted
+	b, err := base64.StdEncoding.DecodeString(m.Body)
+	require.NoError(t, err)
+	b, err = encryption.Decrypt(context.TODO(), provider, "key", m.Attributes[encryption.EncryptedKeyAttribute].BinaryValue, b)
+	require.NoError(t, err)
+	b, err = base64.StdEncoding.DecodeString(string(b))
+	require.NoError(t, err)
+	body, err := compression.Gzip.Decompress(b)
+	require.NoError(t, err)
+	require.Equal(t, `"`+large+`"`, string(body))
+}
+
+func TestEncodeOffload(t *testing.T) {
+	store := &blobStoreMock{blobs: make(map[string][]byte)}
+	large := strings.Repeat("a", 256*1024)
+
+	m, err := Encode(context.TODO(), large, publisher.NewOptions(), Config{Codec: codec.JSON, BlobStore: store})
+	require.NoError(t, err)
+	require.Equal(t, publisher.NumberAttribute(float64(len(large)+2)), m.Attributes[blobstore.PayloadSizeAttribute])
+	p, err := blobstore.ParsePointer(m.Body)
+	require.NoError(t, err)
+	require.Equal(t, `"`+large+`"`, string(store.blobs[p.Key]))
+
+	// Messages below the threshold are published as they are
+	m, err = Encode(context.TODO(), "message", publisher.NewOptions(), Config{Codec: codec.JSON, BlobStore: store})
+	require.NoError(t, err)
+	require.Equal(t, `"message"`, m.Body)
+	require.Len(t, store.blobs, 1)
+
+	m, err = Encode(context.TODO(), "message", publisher.NewOptions(), Config{Codec: codec.JSON, BlobStore: store, OffloadThreshold: 5})
+	require.NoError(t, err)
+	require.Contains(t, m.Attributes, blobstore.PayloadSizeAttribute)
+	require.Len(t, store.blobs, 2)
+
+	storeErr := errors.New("store error")
+	_, err = Encode(context.TODO(), large, publisher.NewOptions(), Config{Codec: codec.JSON, BlobStore: &blobStoreMock{err: storeErr}})
+	require.True(t, errors.Is(err, storeErr))
+}
+
+func stringPtr(s string) *string {
+	return &s
+}
@@ -0,0 +1,125 @@
+package publisher
+
+import (
+	"strconv"
+
+	"github.com/bernardopericacho/htsqs/codec"
+)
+
+const (
+	// StringDataType is the data type of the attributes holding Unicode text
+	StringDataType = "String"
+
+	// NumberDataType is the data type of the attributes holding numeric values
+	NumberDataType = "Number"
+
+	// BinaryDataType is the data type of the attributes holding binary data
+	BinaryDataType = "Binary"
+)
+
+// Attribute is a typed message attribute sent along with the message body.
+// For more information about message attributes go to
+// https://docs.aws.amazon.com/AWSSimpleQueueService/latest/SQSDeveloperGuide/sqs-message-metadata.html
+type Attribute struct {
+
+	// Data type of the attribute: String, Number or Binary. A custom type label can be appended
+	// to the data type, e.g. "Number.float"
+	DataType string
+
+	// Value of String and Number attributes
+	StringValue string
+
+	// Value of Binary attributes
+	BinaryValue []byte
+}
+
+// StringAttribute returns a String attribute holding the given value
+func StringAttribute(value string) Attribute {
+	return Attribute{DataType: StringDataType, StringValue: value}
+}
+
+// NumberAttribute returns a Number attribute holding the given value
+func NumberAttribute(value float64) Attribute {
+	return Attribute{DataType: NumberDataType, StringValue: strconv.FormatFloat(value, 'f', -1, 64)}
+}
+
+// BinaryAttribute returns a Binary attribute holding the given value
+func BinaryAttribute(value []byte) Attribute {
+	return Attribute{DataType: BinaryDataType, BinaryValue: value}
+}
+
+// Size returns the number of bytes the attribute adds to the message size
+func (a Attribute) Size() int {
+	return len(a.DataType) + len(a.StringValue) + len(a.BinaryValue)
+}
+
+// Options holds the settings used when publishing messages
+type Options struct {
+
+	// Message attributes sent along with the message
+	Attributes map[string]Attribute
+
+	// Message group ID of the messages published to a FIFO queue or topic.
+	// Takes precedence over the ID provided by messages implementing GroupedMessage
+	GroupID string
+
+	// Deduplication ID of the messages published to a FIFO queue or topic.
+	// Takes precedence over the ID provided by messages implementing DeduplicatedMessage
+	DeduplicationID string
+
+	// Codec used to encode the messages. Takes precedence over the publisher codec
+	Codec codec.Codec
+}
+
+// Option sets a publishing setting
+type Option func(*Options)
+
+// WithAttribute adds the given attribute to the published messages
+func WithAttribute(name string, attr Attribute) Option {
+	return func(o *Options) {
+		if o.Attributes == nil {
+			o.Attributes = make(map[string]Attribute)
+		}
+		o.Attributes[name] = attr
+	}
+}
+
+// WithAttributes adds all the given attributes to the published messages
+func WithAttributes(attrs map[string]Attribute) Option {
+	return func(o *Options) {
+		for name, attr := range attrs {
+			WithAttribute(name, attr)(o)
+		}
+	}
+}
+
+// WithGroupID sets the message group ID of the messages published to a FIFO queue or topic
+func WithGroupID(id string) Option {
+	return func(o *Options) {
+		o.GroupID = id
+	}
+}
+
+// WithDeduplicationID sets the deduplication ID of the messages published to a FIFO queue or topic.
+// All the messages of a batch would share the same ID, implement DeduplicatedMessage instead
+func WithDeduplicationID(id string) Option {
+	return func(o *Options) {
+		o.DeduplicationID = id
+	}
+}
+
+// WithCodec sets the codec used to encode the published messages
+func WithCodec(c codec.Codec) Option {
+	return func(o *Options) {
+		o.Codec = c
+	}
+}
+
+// NewOptions returns the publishing settings after applying all the given options
+func NewOptions(opts ...Option) *Options {
+	o := new(Options)
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
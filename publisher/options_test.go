@@ -0,0 +1,25 @@
+package publisher
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestOptions(t *testing.T) {
+	o := NewOptions(
+		WithAttribute("event", StringAttribute("created")),
+		WithAttributes(map[string]Attribute{
+			"version":   NumberAttribute(1.5),
+			"signature": BinaryAttribute([]byte{1, 2, 3}),
+		}),
+	)
+
+	require.Equal(t, map[string]Attribute{
+		"event":     {DataType: "String", StringValue: "created"},
+		"version":   {DataType: "Number", StringValue: "1.5"},
+		"signature": {DataType: "Binary", BinaryValue: []byte{1, 2, 3}},
+	}, o.Attributes)
+
+	require.Empty(t, NewOptions().Attributes)
+}
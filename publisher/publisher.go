@@ -2,10 +2,60 @@ package publisher
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
 )
 
-// Publisher is the interface clients can use to publish messages
+// TypeAttribute is the message attribute holding the type of the messages implementing TypedMessage
+const TypeAttribute = "type"
+
+// ErrMessageTooLarge is reported for the messages that exceed the maximum message size allowed by AWS
+var ErrMessageTooLarge = errors.New("message exceeds the maximum size allowed")
+
+// ErrMissingGroupID is returned when publishing a message to a FIFO queue or topic without a message group ID
+var ErrMissingGroupID = errors.New("messages published to a FIFO queue or topic require a message group ID")
+
+// ErrPartialBatch is returned by PublishBatch when at least one of the messages could not be published.
+// The outcome of every message is reported in the returned results
+var ErrPartialBatch = errors.New("some messages of the batch could not be published")
+
+// Publisher is the interface clients can use to publish messages.
+// Messages are encoded with the publisher codec, JSON by default, or the one set through WithCodec
 type Publisher interface {
-	Publish(ctx context.Context, msg json.Marshaler) error
+	Publish(ctx context.Context, msg interface{}, opts ...Option) error
+}
+
+// BatchPublisher is the interface clients can use to publish several messages at once
+type BatchPublisher interface {
+	PublishBatch(ctx context.Context, msgs []interface{}, opts ...Option) ([]BatchResult, error)
+}
+
+// GroupedMessage is the interface implemented by the messages that belong to a message group.
+// Messages published to a FIFO queue or topic require a message group ID. Messages that belong to the same
+// message group are processed in a FIFO manner
+type GroupedMessage interface {
+	MessageGroupID() string
+}
+
+// DeduplicatedMessage is the interface implemented by the messages that provide their own deduplication ID.
+// Messages published to a FIFO queue or topic with the same deduplication ID within the deduplication
+// interval are accepted successfully but not delivered
+type DeduplicatedMessage interface {
+	MessageDeduplicationID() string
+}
+
+// TypedMessage is the interface implemented by the messages that provide their type, e.g. order.created.
+// The type is published in the TypeAttribute attribute unless the publishing options set it,
+// so subscribers can dispatch the messages to the handler of their type
+type TypedMessage interface {
+	MessageType() string
+}
+
+// BatchResult holds the outcome of publishing a single message as part of a batch
+type BatchResult struct {
+
+	// Identifier assigned by AWS to the message. Empty if the message could not be published
+	MessageID string
+
+	// Error found when publishing the message. Nil if the message was published successfully
+	Err error
 }
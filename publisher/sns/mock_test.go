@@ -2,16 +2,54 @@ package sns
 
 import (
 	"context"
+	"fmt"
+	"time"
 
+	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/service/sns"
+
+	"github.com/bernardopericacho/htsqs/metrics"
 )
 
 type snsPublisherMock struct {
-	queue chan<- *string
+	queue       chan<- *string
+	failingBody string
+	batches     int
+	input       *sns.PublishInput
+	batchInput  *sns.PublishBatchInput
 }
 
 func (p *snsPublisherMock) PublishWithContext(ctx context.Context, input *sns.PublishInput, o ...request.Option) (*sns.PublishOutput, error) {
 	p.queue <- input.Message
+	p.input = input
 	return &sns.PublishOutput{}, nil
 }
+
+func (p *snsPublisherMock) PublishBatchWithContext(ctx context.Context, input *sns.PublishBatchInput, o ...request.Option) (*sns.PublishBatchOutput, error) {
+	output := &sns.PublishBatchOutput{}
+	for _, entry := range input.PublishBatchRequestEntries {
+		if *entry.Message == p.failingBody {
+			output.Failed = append(output.Failed, &sns.BatchResultErrorEntry{Id: entry.Id, Code: aws.String("InternalError"), Message: aws.String("failed")})
+			continue
+		}
+		p.queue <- entry.Message
+		output.Successful = append(output.Successful, &sns.PublishBatchResultEntry{Id: entry.Id, MessageId: aws.String(fmt.Sprintf("id-%s", *entry.Id))})
+	}
+	p.batches++
+	p.batchInput = input
+	return output, nil
+}
+
+type metricsMock struct {
+	metrics.Metrics
+	published int
+	errors    int
+}
+
+func (m *metricsMock) Publish(destination string, duration time.Duration, err error) {
+	m.published++
+	if err != nil {
+		m.errors++
+	}
+}
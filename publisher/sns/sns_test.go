@@ -2,10 +2,21 @@ package sns
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"strings"
 	"testing"
 
+	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/sns"
+	"github.com/aws/aws-sdk-go/service/sns/snsiface"
 	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/propagation"
+
+	"github.com/bernardopericacho/htsqs/codec"
+	"github.com/bernardopericacho/htsqs/metrics"
+	"github.com/bernardopericacho/htsqs/publisher"
 )
 
 type jsonString string
@@ -26,6 +37,151 @@ func TestPublisher(t *testing.T) {
 	require.Equal(t, *publishedMessage, `{"msg":"message"}`)
 }
 
+func TestPublisherClient(t *testing.T) {
+	// AWS SDK clients can be used as they are
+	var _ SNSClient = snsiface.SNSAPI(nil)
+
+	queue := make(chan *string, 1)
+	defer close(queue)
+	pubs := New(Config{SNSClient: &snsPublisherMock{queue: queue}})
+	require.Nil(t, pubs.cfg.AWSSession)
+
+	require.NoError(t, pubs.Publish(context.TODO(), jsonString(`{"msg":"message"}`)))
+	require.Equal(t, `{"msg":"message"}`, *<-queue)
+}
+
+func TestPublisherAttributes(t *testing.T) {
+	queue := make(chan *string, 1)
+	defer close(queue)
+	mock := &snsPublisherMock{queue: queue}
+	pubs := New(Config{})
+	pubs.sns = mock
+
+	testString := jsonString(`{"msg":"message"}`)
+	require.NoError(t, pubs.Publish(context.TODO(), testString,
+		publisher.WithAttribute("event", publisher.StringAttribute("created")),
+		publisher.WithAttributes(map[string]publisher.Attribute{
+			"version":   publisher.NumberAttribute(2),
+			"signature": publisher.BinaryAttribute([]byte{1, 2}),
+		}),
+	))
+	<-queue
+	require.Equal(t, map[string]*sns.MessageAttributeValue{
+		"event":     {DataType: aws.String("String"), StringValue: aws.String("created")},
+		"version":   {DataType: aws.String("Number"), StringValue: aws.String("2")},
+		"signature": {DataType: aws.String("Binary"), BinaryValue: []byte{1, 2}},
+	}, mock.input.MessageAttributes)
+}
+
+func TestPublisherCodec(t *testing.T) {
+	queue := make(chan *string, 2)
+	defer close(queue)
+	mock := &snsPublisherMock{queue: queue}
+	pubs := New(Config{Codec: codec.Raw})
+	pubs.sns = mock
+
+	require.NoError(t, pubs.Publish(context.TODO(), []byte{0, 1, 2}))
+	require.Equal(t, "AAEC", *<-queue)
+	require.Equal(t, map[string]*sns.MessageAttributeValue{
+		codec.ContentTypeAttribute: {DataType: aws.String("String"), StringValue: aws.String("application/octet-stream")},
+	}, mock.input.MessageAttributes)
+
+	require.NoError(t, pubs.Publish(context.TODO(), jsonString(`{"msg":"message"}`), publisher.WithCodec(codec.JSON)))
+	require.Equal(t, `{"msg":"message"}`, *<-queue)
+	require.Empty(t, mock.input.MessageAttributes)
+
+	require.True(t, errors.Is(pubs.Publish(context.TODO(), 1), codec.ErrUnsupportedType))
+}
+
+type groupedMessage struct {
+	jsonString
+	group string
+}
+
+func (m groupedMessage) MessageGroupID() string {
+	return m.group
+}
+
+func TestPublisherFIFO(t *testing.T) {
+	queue := make(chan *string, 10)
+	defer close(queue)
+	mock := &snsPublisherMock{queue: queue}
+	pubs := New(Config{TopicArn: "myTopic.fifo", ContentBasedDeduplication: true})
+	pubs.sns = mock
+
+	require.Equal(t, publisher.ErrMissingGroupID, pubs.Publish(context.TODO(), jsonString(`{"msg":"message"}`)))
+
+	require.NoError(t, pubs.Publish(context.TODO(), groupedMessage{`{"msg":"message"}`, "group"}))
+	<-queue
+	require.Equal(t, "group", *mock.input.MessageGroupId)
+	require.NotEmpty(t, *mock.input.MessageDeduplicationId)
+
+	require.NoError(t, pubs.Publish(context.TODO(), jsonString(`{"msg":"message"}`), publisher.WithGroupID("options"), publisher.WithDeduplicationID("id")))
+	<-queue
+	require.Equal(t, "options", *mock.input.MessageGroupId)
+	require.Equal(t, "id", *mock.input.MessageDeduplicationId)
+
+	results, err := pubs.PublishBatch(context.TODO(), []interface{}{
+		groupedMessage{`{"msg":"message 1"}`, "group 1"},
+		jsonString(`{"msg":"message 2"}`),
+		groupedMessage{`{"msg":"message 3"}`, "group 2"},
+	})
+	require.Equal(t, publisher.ErrPartialBatch, err)
+	require.NoError(t, results[0].Err)
+	require.Equal(t, publisher.ErrMissingGroupID, results[1].Err)
+	require.NoError(t, results[2].Err)
+	entries := mock.batchInput.PublishBatchRequestEntries
+	require.Len(t, entries, 2)
+	require.Equal(t, "group 1", *entries[0].MessageGroupId)
+	require.Equal(t, "group 2", *entries[1].MessageGroupId)
+	require.NotEqual(t, *entries[0].MessageDeduplicationId, *entries[1].MessageDeduplicationId)
+}
+
+func TestPublisherBatch(t *testing.T) {
+	queue := make(chan *string, 20)
+	defer close(queue)
+	mock := &snsPublisherMock{queue: queue, failingBody: `{"msg":"fail"}`}
+	pubs := New(Config{})
+	pubs.sns = mock
+
+	var msgs []interface{}
+	for i := 0; i < 12; i++ {
+		msgs = append(msgs, jsonString(fmt.Sprintf(`{"msg":"message %d"}`, i)))
+	}
+	msgs = append(msgs, jsonString(`{"msg":"fail"}`), jsonString(fmt.Sprintf(`"%s"`, strings.Repeat("a", 256*1024))))
+
+	results, err := pubs.PublishBatch(context.TODO(), msgs)
+	require.Equal(t, publisher.ErrPartialBatch, err)
+	require.Len(t, results, len(msgs))
+	require.Equal(t, 2, mock.batches)
+	require.Len(t, queue, 12)
+
+	for i := 0; i < 12; i++ {
+		require.NoError(t, results[i].Err)
+		require.Equal(t, fmt.Sprintf("id-%d", i), results[i].MessageID)
+	}
+	require.EqualError(t, results[12].Err, "InternalError: failed")
+	require.Equal(t, publisher.ErrMessageTooLarge, results[13].Err)
+
+	results, err = pubs.PublishBatch(context.TODO(), msgs[:3])
+	require.NoError(t, err)
+	require.Len(t, results, 3)
+}
+
+func TestPublisherMetrics(t *testing.T) {
+	queue := make(chan *string, 10)
+	defer close(queue)
+	metricsRecorder := &metricsMock{}
+	pubs := New(Config{Metrics: metricsRecorder})
+	pubs.sns = &snsPublisherMock{queue: queue, failingBody: `{"msg":"fail"}`}
+
+	require.NoError(t, pubs.Publish(context.TODO(), jsonString(`{"msg":"message"}`)))
+	_, err := pubs.PublishBatch(context.TODO(), []interface{}{jsonString(`{"msg":"message"}`), jsonString(`{"msg":"fail"}`)})
+	require.Equal(t, publisher.ErrPartialBatch, err)
+	require.Equal(t, 3, metricsRecorder.published)
+	require.Equal(t, 1, metricsRecorder.errors)
+}
+
 func TestPublisherDefaults(t *testing.T) {
 
 	tt := []struct {
@@ -35,13 +191,13 @@ func TestPublisherDefaults(t *testing.T) {
 	}{
 		{
 			"Custom parameters",
-			Config{AWSSession: session.Must(session.NewSession()), TopicArn: "myTopicARN"},
-			Config{TopicArn: "myTopicARN"},
+			Config{AWSSession: session.Must(session.NewSession()), TopicArn: "myTopicARN", Codec: codec.Raw, Metrics: metrics.Nop, Propagator: propagation.TraceContext{}},
+			Config{TopicArn: "myTopicARN", Codec: codec.Raw, Metrics: metrics.Nop, Propagator: propagation.TraceContext{}},
 		},
 		{
 			"Use defaults parameters",
 			Config{},
-			Config{},
+			Config{Codec: codec.JSON, Metrics: metrics.Nop, Propagator: propagation.TraceContext{}},
 		},
 	}
 
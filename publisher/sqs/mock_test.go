@@ -2,16 +2,54 @@ package sns
 
 import (
 	"context"
+	"fmt"
+	"time"
 
+	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/service/sqs"
+
+	"github.com/bernardopericacho/htsqs/metrics"
 )
 
 type sqsPublisherMock struct {
-	queue chan<- *string
+	queue       chan<- *string
+	failingBody string
+	batches     int
+	input       *sqs.SendMessageInput
+	batchInput  *sqs.SendMessageBatchInput
 }
 
 func (p *sqsPublisherMock) SendMessageWithContext(ctx context.Context, input *sqs.SendMessageInput, opts ...request.Option) (*sqs.SendMessageOutput, error) {
 	p.queue <- input.MessageBody
+	p.input = input
 	return &sqs.SendMessageOutput{}, nil
 }
+
+func (p *sqsPublisherMock) SendMessageBatchWithContext(ctx context.Context, input *sqs.SendMessageBatchInput, opts ...request.Option) (*sqs.SendMessageBatchOutput, error) {
+	output := &sqs.SendMessageBatchOutput{}
+	for _, entry := range input.Entries {
+		if *entry.MessageBody == p.failingBody {
+			output.Failed = append(output.Failed, &sqs.BatchResultErrorEntry{Id: entry.Id, Code: aws.String("InternalError"), Message: aws.String("failed")})
+			continue
+		}
+		p.queue <- entry.MessageBody
+		output.Successful = append(output.Successful, &sqs.SendMessageBatchResultEntry{Id: entry.Id, MessageId: aws.String(fmt.Sprintf("id-%s", *entry.Id))})
+	}
+	p.batches++
+	p.batchInput = input
+	return output, nil
+}
+
+type metricsMock struct {
+	metrics.Metrics
+	published int
+	errors    int
+}
+
+func (m *metricsMock) Publish(destination string, duration time.Duration, err error) {
+	m.published++
+	if err != nil {
+		m.errors++
+	}
+}
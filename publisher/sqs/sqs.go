@@ -2,48 +2,111 @@ package sns
 
 import (
 	"context"
-	"encoding/json"
+	"strconv"
+	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/sqs"
+	"go.opentelemetry.io/otel/propagation"
+
+	"github.com/bernardopericacho/htsqs/blobstore"
+	"github.com/bernardopericacho/htsqs/codec"
+	"github.com/bernardopericacho/htsqs/compression"
+	"github.com/bernardopericacho/htsqs/encryption"
+	"github.com/bernardopericacho/htsqs/metrics"
+	"github.com/bernardopericacho/htsqs/publisher"
+	"github.com/bernardopericacho/htsqs/publisher/internal/batch"
+	"github.com/bernardopericacho/htsqs/publisher/internal/message"
 )
 
-// sender is the interface to sqs.SQS. Its sole purpose is to make
-// Publisher.service and interface that we can mock for testing.
-type sender interface {
+// SQSClient is the subset of sqsiface.SQSAPI used by the publisher. It allows to publish through
+// a custom client, such as one configured with a custom endpoint or wrapped with instrumentation
+type SQSClient interface {
 	SendMessageWithContext(ctx aws.Context, input *sqs.SendMessageInput, opts ...request.Option) (*sqs.SendMessageOutput, error)
+	SendMessageBatchWithContext(ctx aws.Context, input *sqs.SendMessageBatchInput, opts ...request.Option) (*sqs.SendMessageBatchOutput, error)
 }
 
 // Config holds the info required to work with AWS SQS to publish a message
 type Config struct {
 
-	// AWS session
+	// AWS session. Not used when SQSClient is set
 	AWSSession *session.Session
 
+	// Client used to publish the messages, e.g. a sqsiface.SQSAPI configured to use LocalStack.
+	// Built from AWSSession by default
+	SQSClient SQSClient
+
 	// SQS queue where the publisher is going to push messages to
 	QueueURL string
+
+	// Generate the deduplication ID of the messages published to a FIFO queue from the SHA-256 hash
+	// of their body when neither the message nor the publishing options provide one.
+	// Not needed when content-based deduplication is enabled on the queue
+	ContentBasedDeduplication bool
+
+	// Codec used to encode the messages unless the publishing options set one. JSON by default
+	Codec codec.Codec
+
+	// Metrics recording the latency and errors of the published messages. Nothing is recorded by default
+	Metrics metrics.Metrics
+
+	// Propagator injecting the trace context of the ctx passed to Publish into the message attributes,
+	// so subscribers can continue the trace. W3C trace context by default, adding the traceparent and
+	// tracestate attributes to the messages published within a trace
+	Propagator propagation.TextMapPropagator
+
+	// Compressor compressing the message bodies larger than CompressionThreshold. Compressed bodies are base64 encoded
+	// and the content-encoding attribute is set, so subscribers decompress them. Bodies are not compressed by default
+	Compressor compression.Compressor
+
+	// Body size in bytes above which the messages are compressed. 1 KB by default
+	CompressionThreshold int
+
+	// Provider of the data keys the message bodies are encrypted under with AES-GCM, after being compressed.
+	// The encrypted data key and the ID of its master key are sent in the message attributes, so subscribers
+	// configured with a provider holding the master key decrypt the bodies. Bodies are not encrypted by default
+	KeyProvider encryption.KeyProvider
+
+	// Store the message payloads exceeding OffloadThreshold are offloaded to, publishing a pointer message instead.
+	// Subscribers need the same store to fetch the payloads back. Messages are not offloaded by default
+	BlobStore blobstore.BlobStore
+
+	// Size in bytes, including the message attributes, above which the messages are offloaded to the BlobStore.
+	// The maximum message size allowed by AWS, 256 KB, by default
+	OffloadThreshold int
 }
 
 // Publisher is the AWS SNS message publisher
 type Publisher struct {
-	sqs sender
+	sqs SQSClient
 	cfg Config
 }
 
 // Publish allows SQS Publisher to implement the publisher.Publisher interface
 // and publish messages to an AWS SQS backend
-func (p *Publisher) Publish(ctx context.Context, msg json.Marshaler) error {
-	b, err := json.Marshal(msg)
+func (p *Publisher) Publish(ctx context.Context, msg interface{}, opts ...publisher.Option) error {
+	start := time.Now()
+	err := p.publish(ctx, msg, opts...)
+	p.cfg.Metrics.Publish(p.cfg.QueueURL, time.Since(start), err)
+	return err
+}
 
+// publish encodes and publishes a single message
+func (p *Publisher) publish(ctx context.Context, msg interface{}, opts ...publisher.Option) error {
+	m, err := message.Encode(ctx, msg, publisher.NewOptions(opts...), p.encoding())
 	if err != nil {
 		return err
 	}
 
 	input := &sqs.SendMessageInput{
-		MessageBody: aws.String(string(b)),
-		QueueUrl:    &p.cfg.QueueURL,
+		MessageBody:            &m.Body,
+		MessageAttributes:      messageAttributes(m.Attributes),
+		MessageGroupId:         m.GroupID,
+		MessageDeduplicationId: m.DeduplicationID,
+		QueueUrl:               &p.cfg.QueueURL,
 	}
 
 	if err := input.Validate(); err != nil {
@@ -54,14 +117,141 @@ func (p *Publisher) Publish(ctx context.Context, msg json.Marshaler) error {
 	return err
 }
 
+// PublishBatch allows SQS Publisher to implement the publisher.BatchPublisher interface
+// and publish several messages to an AWS SQS backend using as few requests as possible.
+// Messages are grouped in chunks that fit in a single SendMessageBatch request.
+// The returned results follow the same order as msgs and publisher.ErrPartialBatch
+// is returned if any of the messages could not be published
+func (p *Publisher) PublishBatch(ctx context.Context, msgs []interface{}, opts ...publisher.Option) ([]publisher.BatchResult, error) {
+	results := make([]publisher.BatchResult, len(msgs))
+	encoded := make([]*message.Message, len(msgs))
+	sizes := make([]int, len(msgs))
+	durations := make([]time.Duration, len(msgs))
+
+	o := publisher.NewOptions(opts...)
+	for i, msg := range msgs {
+		m, err := message.Encode(ctx, msg, o, p.encoding())
+		if err != nil {
+			results[i].Err = err
+			sizes[i] = -1
+			continue
+		}
+		encoded[i] = m
+		sizes[i] = m.Size()
+	}
+
+	for _, chunk := range batch.Split(sizes) {
+		input := &sqs.SendMessageBatchInput{
+			QueueUrl: &p.cfg.QueueURL,
+		}
+		for _, i := range chunk {
+			input.Entries = append(input.Entries, &sqs.SendMessageBatchRequestEntry{
+				Id:                     aws.String(strconv.Itoa(i)),
+				MessageBody:            &encoded[i].Body,
+				MessageAttributes:      messageAttributes(encoded[i].Attributes),
+				MessageGroupId:         encoded[i].GroupID,
+				MessageDeduplicationId: encoded[i].DeduplicationID,
+			})
+		}
+
+		start := time.Now()
+		output, err := p.sendBatch(ctx, input)
+		for _, i := range chunk {
+			durations[i] = time.Since(start)
+		}
+		if err != nil {
+			for _, i := range chunk {
+				results[i].Err = err
+			}
+			continue
+		}
+
+		for _, entry := range output.Successful {
+			i, _ := strconv.Atoi(aws.StringValue(entry.Id))
+			results[i].MessageID = aws.StringValue(entry.MessageId)
+		}
+		for _, entry := range output.Failed {
+			i, _ := strconv.Atoi(aws.StringValue(entry.Id))
+			results[i].Err = awserr.New(aws.StringValue(entry.Code), aws.StringValue(entry.Message), nil)
+		}
+	}
+
+	var err error
+	for i, result := range results {
+		p.cfg.Metrics.Publish(p.cfg.QueueURL, durations[i], result.Err)
+		if result.Err != nil {
+			err = publisher.ErrPartialBatch
+		}
+	}
+
+	return results, err
+}
+
+// encoding returns the publisher settings used to encode messages
+func (p *Publisher) encoding() message.Config {
+	return message.Config{
+		Destination:               p.cfg.QueueURL,
+		Codec:                     p.cfg.Codec,
+		ContentBasedDeduplication: p.cfg.ContentBasedDeduplication,
+		Propagator:                p.cfg.Propagator,
+		Compressor:                p.cfg.Compressor,
+		CompressionThreshold:      p.cfg.CompressionThreshold,
+		KeyProvider:               p.cfg.KeyProvider,
+		BlobStore:                 p.cfg.BlobStore,
+		OffloadThreshold:          p.cfg.OffloadThreshold,
+	}
+}
+
+func (p *Publisher) sendBatch(ctx context.Context, input *sqs.SendMessageBatchInput) (*sqs.SendMessageBatchOutput, error) {
+	if err := input.Validate(); err != nil {
+		return nil, err
+	}
+	return p.sqs.SendMessageBatchWithContext(ctx, input)
+}
+
+// messageAttributes converts the publishing attributes to AWS SQS message attributes
+func messageAttributes(attrs map[string]publisher.Attribute) map[string]*sqs.MessageAttributeValue {
+	if len(attrs) == 0 {
+		return nil
+	}
+
+	values := make(map[string]*sqs.MessageAttributeValue, len(attrs))
+	for name, attr := range attrs {
+		value := &sqs.MessageAttributeValue{DataType: aws.String(attr.DataType)}
+		if attr.BinaryValue != nil {
+			value.BinaryValue = attr.BinaryValue
+		} else {
+			value.StringValue = aws.String(attr.StringValue)
+		}
+		values[name] = value
+	}
+	return values
+}
+
 func defaultPublisherConfig(cfg *Config) {
-	if cfg.AWSSession == nil {
+	if cfg.AWSSession == nil && cfg.SQSClient == nil {
 		cfg.AWSSession = session.Must(session.NewSession())
 	}
+
+	if cfg.Codec == nil {
+		cfg.Codec = codec.JSON
+	}
+
+	if cfg.Metrics == nil {
+		cfg.Metrics = metrics.Nop
+	}
+
+	if cfg.Propagator == nil {
+		cfg.Propagator = propagation.TraceContext{}
+	}
 }
 
 // New creates a new AWS SQS publisher
 func New(cfg Config) *Publisher {
 	defaultPublisherConfig(&cfg)
-	return &Publisher{cfg: cfg, sqs: sqs.New(cfg.AWSSession)}
+	client := cfg.SQSClient
+	if client == nil {
+		client = sqs.New(cfg.AWSSession)
+	}
+	return &Publisher{cfg: cfg, sqs: client}
 }
@@ -0,0 +1,120 @@
+package subscriber
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/sqs"
+)
+
+const (
+	// maxAckBatchSize is the maximum number of messages AWS SQS allows to delete in a single request
+	maxAckBatchSize = 10
+)
+
+// acker collects the acknowledged messages and deletes them from AWS SQS in batches.
+// A batch is deleted as soon as it is full or when the flush interval elapses
+type acker struct {
+	sub   *Subscriber
+	errCh chan<- error
+	acks  chan *SQSMessage
+	done  chan struct{}
+
+	// closed is protected by mu so messages are never queued once the acker is closed
+	mu     sync.RWMutex
+	closed bool
+}
+
+func newAcker(s *Subscriber, errCh chan<- error) *acker {
+	return &acker{sub: s, errCh: errCh, acks: make(chan *SQSMessage, maxAckBatchSize), done: make(chan struct{})}
+}
+
+// add queues the message for deletion. Reports false if the acker is already closed
+func (a *acker) add(m *SQSMessage) bool {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	if a.closed {
+		return false
+	}
+	a.acks <- m
+	return true
+}
+
+// close deletes the pending messages and stops the acker.
+// Blocks until all the pending messages are processed
+func (a *acker) close() {
+	a.mu.Lock()
+	a.closed = true
+	close(a.acks)
+	a.mu.Unlock()
+	<-a.done
+}
+
+func (a *acker) run(flushInterval time.Duration) {
+	defer close(a.done)
+
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+
+	var pending []*SQSMessage
+	for {
+		select {
+		case m, ok := <-a.acks:
+			if !ok {
+				a.flush(pending)
+				return
+			}
+			pending = append(pending, m)
+			if len(pending) == maxAckBatchSize {
+				a.flush(pending)
+				pending = nil
+			}
+		case <-ticker.C:
+			a.flush(pending)
+			pending = nil
+		}
+	}
+}
+
+// flush deletes the messages from AWS SQS. Errors are sent to the error channel
+func (a *acker) flush(msgs []*SQSMessage) {
+	if len(msgs) == 0 {
+		return
+	}
+
+	input := &sqs.DeleteMessageBatchInput{QueueUrl: &a.sub.cfg.SqsQueueURL}
+	for i, m := range msgs {
+		input.Entries = append(input.Entries, &sqs.DeleteMessageBatchRequestEntry{
+			Id:            aws.String(strconv.Itoa(i)),
+			ReceiptHandle: m.rawMessage.ReceiptHandle,
+		})
+	}
+
+	output, err := a.sub.sqs.DeleteMessageBatchWithContext(context.Background(), input)
+	if err != nil {
+		for range msgs {
+			a.sub.cfg.Metrics.Ack(a.sub.cfg.SqsQueueURL, err)
+		}
+		a.errCh <- err
+		return
+	}
+
+	for _, entry := range output.Successful {
+		a.sub.cfg.Metrics.Ack(a.sub.cfg.SqsQueueURL, nil)
+		i, _ := strconv.Atoi(aws.StringValue(entry.Id))
+		if err := msgs[i].deletePayload(context.Background()); err != nil {
+			a.errCh <- err
+		}
+	}
+	for _, entry := range output.Failed {
+		i, _ := strconv.Atoi(aws.StringValue(entry.Id))
+		err := awserr.New(aws.StringValue(entry.Code), aws.StringValue(entry.Message), nil)
+		a.sub.cfg.Metrics.Ack(a.sub.cfg.SqsQueueURL, err)
+		a.errCh <- fmt.Errorf("error when deleting message %s: %w", aws.StringValue(msgs[i].rawMessage.MessageId), err)
+	}
+}
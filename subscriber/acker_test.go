@@ -0,0 +1,71 @@
+package subscriber
+
+import (
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAcker(t *testing.T) {
+	numMessages := 25
+	queue := make(chan *SQSMessage)
+	defer close(queue)
+	mock := &sqsMock{queue: queue, failingReceiptHandle: "Message: 3"}
+	subs := New(Config{AckFlushInterval: time.Hour})
+	subs.sqs = mock
+
+	go func() {
+		for i := 0; i < numMessages; i++ {
+			message := fmt.Sprintf("Message: %d", i)
+			queue <- &SQSMessage{sub: subs, rawMessage: &sqs.Message{Body: &message}}
+		}
+	}()
+
+	messages, errCh, err := subs.Consume()
+	require.NoError(t, err)
+
+	for i := 0; i < numMessages; i++ {
+		require.NoError(t, (<-messages).Done())
+	}
+
+	// Full batches are deleted right away
+	require.Eventually(t, func() bool { return atomic.LoadInt32(&mock.batchDeletes) == 2 }, time.Second, time.Millisecond)
+	require.EqualError(t, <-errCh, "error when deleting message Message: 3: ReceiptHandleIsInvalid: invalid")
+
+	// Pending messages are deleted on stop
+	require.NoError(t, subs.Stop())
+	require.Equal(t, int32(3), atomic.LoadInt32(&mock.batchDeletes))
+	require.Equal(t, int32(numMessages-1), atomic.LoadInt32(&mock.deletedMessages))
+	require.NoError(t, <-errCh)
+
+	// Messages done once the subscriber is stopped are deleted one by one
+	message := "Message"
+	m := &SQSMessage{sub: subs, rawMessage: &sqs.Message{Body: &message, ReceiptHandle: &message}}
+	require.NoError(t, m.Done())
+	require.Equal(t, int32(numMessages), atomic.LoadInt32(&mock.deletedMessages))
+}
+
+func TestAckerFlushInterval(t *testing.T) {
+	queue := make(chan *SQSMessage)
+	defer close(queue)
+	mock := &sqsMock{queue: queue}
+	subs := New(Config{AckFlushInterval: 5 * time.Millisecond})
+	subs.sqs = mock
+
+	go func() {
+		message := fmt.Sprintf("Message")
+		queue <- &SQSMessage{sub: subs, rawMessage: &sqs.Message{Body: &message}}
+	}()
+
+	messages, _, err := subs.Consume()
+	require.NoError(t, err)
+	require.NoError(t, (<-messages).Done())
+
+	require.Eventually(t, func() bool { return atomic.LoadInt32(&mock.deletedMessages) == 1 }, time.Second, time.Millisecond)
+	require.Equal(t, int32(1), atomic.LoadInt32(&mock.batchDeletes))
+	require.NoError(t, subs.Stop())
+}
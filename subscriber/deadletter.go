@@ -0,0 +1,98 @@
+package subscriber
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+
+	"github.com/bernardopericacho/htsqs/blobstore"
+	"github.com/bernardopericacho/htsqs/codec"
+	"github.com/bernardopericacho/htsqs/compression"
+	"github.com/bernardopericacho/htsqs/encryption"
+	"github.com/bernardopericacho/htsqs/publisher"
+)
+
+const (
+	// FailureReasonAttribute is the attribute holding the error returned by the Handler of a dead-lettered message
+	FailureReasonAttribute = "x-failure-reason"
+
+	// FailedAtAttribute is the attribute holding the time, in RFC 3339 format, a message was dead-lettered
+	FailedAtAttribute = "x-failed-at"
+
+	// ReceiveCountAttribute is the attribute holding the number of times a dead-lettered message was received
+	ReceiveCountAttribute = "x-receive-count"
+
+	// SourceQueueAttribute is the attribute holding the URL of the queue a dead-lettered message was received from
+	SourceQueueAttribute = "x-source-queue-url"
+)
+
+// ErrDeadLetter is returned by a Handler when the message can never be processed.
+// The message is sent to the worker dead-letter publisher and deleted. It can be wrapped to give more details.
+// When the worker has no dead-letter publisher the message is nacked like on any other error
+var ErrDeadLetter = errors.New("dead letter")
+
+// shouldDeadLetter reports whether a message whose Handler returned the given error must be dead-lettered
+func (w *Worker) shouldDeadLetter(m *SQSMessage, err error) bool {
+	if w.config.DeadLetterPublisher == nil {
+		return false
+	}
+	if errors.Is(err, ErrDeadLetter) {
+		return true
+	}
+	return w.config.MaxReceiveCount > 0 && m.ReceiveCount() >= w.config.MaxReceiveCount
+}
+
+// deadLetter publishes the message to the dead-letter publisher along with its attributes and the failure details
+func (w *Worker) deadLetter(ctx context.Context, m *SQSMessage, err error) error {
+	return forward(ctx, w.config.DeadLetterPublisher, m, err)
+}
+
+// forward publishes the message to the given publisher along with its attributes and the failure details.
+// The body is published verbatim with its content type, as it is returned by Body: offloaded payloads, encrypted
+// and compressed bodies are offloaded, encrypted or compressed again by the publisher if it is configured to. Encrypted messages are published decrypted unless the publisher has a KeyProvider.
+// Messages received from FIFO queues keep their message group ID and their deduplication ID, or their message ID
+// when they have no deduplication ID, so they can be forwarded to FIFO queues and topics
+func forward(ctx context.Context, p publisher.Publisher, m *SQSMessage, err error) error {
+	attributes := make(map[string]publisher.Attribute, len(m.MessageAttributes())+4)
+	for name, value := range m.MessageAttributes() {
+		if skipForwardedAttribute(m, name) {
+			continue
+		}
+		attributes[name] = publisher.Attribute{
+			DataType:    aws.StringValue(value.DataType),
+			StringValue: aws.StringValue(value.StringValue),
+			BinaryValue: value.BinaryValue,
+		}
+	}
+	attributes[FailureReasonAttribute] = publisher.StringAttribute(err.Error())
+	attributes[FailedAtAttribute] = publisher.StringAttribute(time.Now().UTC().Format(time.RFC3339))
+	attributes[ReceiveCountAttribute] = publisher.Attribute{DataType: publisher.NumberDataType, StringValue: strconv.Itoa(m.ReceiveCount())}
+	attributes[SourceQueueAttribute] = publisher.StringAttribute(m.sub.cfg.SqsQueueURL)
+
+	opts := []publisher.Option{publisher.WithAttributes(attributes), publisher.WithCodec(codec.Verbatim(m.ContentType()))}
+	if groupID := m.MessageGroupID(); groupID != "" {
+		deduplicationID := m.MessageDeduplicationID()
+		if deduplicationID == "" {
+			deduplicationID = m.MessageID()
+		}
+		opts = append(opts, publisher.WithGroupID(groupID), publisher.WithDeduplicationID(deduplicationID))
+	}
+	return p.Publish(ctx, m.Body(), opts...)
+}
+
+// skipForwardedAttribute reports whether the attribute describes a transformation of the body already undone by Body
+func skipForwardedAttribute(m *SQSMessage, name string) bool {
+	switch name {
+	case blobstore.PayloadSizeAttribute:
+		return m.payloadKey != ""
+	case compression.ContentEncodingAttribute:
+		_, encrypted := m.MessageAttributes()[encryption.KeyIDAttribute]
+		return !encrypted || m.decrypted
+	case encryption.KeyIDAttribute, encryption.EncryptedKeyAttribute:
+		return m.decrypted
+	}
+	return false
+}
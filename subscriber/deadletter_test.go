@@ -0,0 +1,142 @@
+package subscriber
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync/atomic"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/stretchr/testify/require"
+
+	"github.com/bernardopericacho/htsqs/codec"
+	"github.com/bernardopericacho/htsqs/publisher"
+)
+
+func TestWorkerDeadLetter(t *testing.T) {
+
+	tt := []struct {
+		name              string
+		body              string
+		contentType       string
+		receiveCount      int
+		err               error
+		publishErr        error
+		expectedPublished string
+		expectedDeletes   int32
+	}{
+		{
+			"Terminal error",
+			`{"msg":"message"}`,
+			"",
+			1,
+			fmt.Errorf("invalid message: %w", ErrDeadLetter),
+			nil,
+			`{"msg":"message"}`,
+			1,
+		},
+		{
+			"Max receive count reached",
+			"message",
+			"",
+			3,
+			errors.New("processing error"),
+			nil,
+			"message",
+			1,
+		},
+		{
+			"Max receive count not reached",
+			"message",
+			"",
+			2,
+			errors.New("processing error"),
+			nil,
+			"",
+			0,
+		},
+		{
+			"Dead-letter publisher error",
+			"message",
+			"",
+			1,
+			ErrDeadLetter,
+			errors.New("publish error"),
+			"",
+			0,
+		},
+		{
+			"Binary message",
+			"AAEC",
+			"application/octet-stream",
+			1,
+			ErrDeadLetter,
+			nil,
+			"AAEC",
+			1,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			queue := make(chan *SQSMessage)
+			defer close(queue)
+			mock := &sqsMock{queue: queue}
+			subs := New(Config{SqsQueueURL: "myQueueURL"})
+			subs.sqs = mock
+
+			published := make(chan []byte, 1)
+			options := make(chan *publisher.Options, 1)
+			worker := NewWorker(WorkerConfig{
+				Subscriber: subs,
+				Handler: func(ctx context.Context, m *SQSMessage) error {
+					return tc.err
+				},
+				DeadLetterPublisher: &publisherMock{published: published, options: options, err: tc.publishErr},
+				MaxReceiveCount:     3,
+			})
+			handled := dispatched(worker)
+
+			errsChannelStop := make(chan error)
+			go func() {
+				receiveCount := strconv.Itoa(tc.receiveCount)
+				messageAttributes := map[string]*sqs.MessageAttributeValue{"event": {DataType: aws.String("String"), StringValue: aws.String("created")}}
+				if tc.contentType != "" {
+					messageAttributes[codec.ContentTypeAttribute] = &sqs.MessageAttributeValue{DataType: aws.String("String"), StringValue: &tc.contentType}
+				}
+				queue <- &SQSMessage{sub: subs, rawMessage: &sqs.Message{
+					Body:              &tc.body,
+					Attributes:        map[string]*string{sqs.MessageSystemAttributeNameApproximateReceiveCount: &receiveCount},
+					MessageAttributes: messageAttributes,
+				}}
+				<-handled
+				errsChannelStop <- worker.Stop()
+				close(errsChannelStop)
+			}()
+
+			require.Equal(t, ErrWorkerClosed, worker.Start(context.TODO()))
+			require.NoError(t, <-errsChannelStop)
+			require.Equal(t, tc.expectedDeletes, atomic.LoadInt32(&mock.deletedMessages))
+
+			if tc.expectedPublished == "" {
+				require.Empty(t, published)
+				return
+			}
+			require.Equal(t, tc.expectedPublished, string(<-published))
+			o := <-options
+			if tc.contentType != "" {
+				require.Equal(t, tc.contentType, o.Codec.ContentType())
+				require.False(t, o.Codec.Binary())
+			}
+			attributes := o.Attributes
+			require.Equal(t, publisher.StringAttribute("created"), attributes["event"])
+			require.Equal(t, publisher.StringAttribute(tc.err.Error()), attributes[FailureReasonAttribute])
+			require.Equal(t, publisher.NumberAttribute(float64(tc.receiveCount)), attributes[ReceiveCountAttribute])
+			require.Equal(t, publisher.StringAttribute("myQueueURL"), attributes[SourceQueueAttribute])
+			require.NotEmpty(t, attributes[FailedAtAttribute].StringValue)
+		})
+	}
+}
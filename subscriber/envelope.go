@@ -0,0 +1,218 @@
+package subscriber
+
+import (
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sqs"
+)
+
+// ErrInvalidSNSSignature is sent to the subscriber error channel when the signature of an SNS notification
+// can not be verified. The message is not pushed to the messages channel
+var ErrInvalidSNSSignature = errors.New("invalid SNS notification signature")
+
+// defaultCertificateTimeout is the timeout of the default HTTP client downloading the SNS signing certificates
+const defaultCertificateTimeout = 10 * time.Second
+
+// defaultCertificateClient downloads the SNS signing certificates when the HTTPCertificateSource has no client
+var defaultCertificateClient = &http.Client{Timeout: defaultCertificateTimeout}
+
+// defaultSNSCertificateHost matches the hosts AWS SNS signing certificates are downloaded from
+var defaultSNSCertificateHost = regexp.MustCompile(`^sns\.[a-z0-9-]+\.amazonaws\.com(\.cn)?$`)
+
+// CertificateSource provides the certificates used to verify the signature of SNS notifications
+type CertificateSource interface {
+	Certificate(certURL string) (*x509.Certificate, error)
+}
+
+// HTTPCertificateSource downloads the SNS signing certificates from their URL and caches them.
+// Only certificates served over HTTPS by the hosts matching HostPattern are downloaded
+type HTTPCertificateSource struct {
+
+	// HTTP client used to download the certificates. A client with a 10 seconds timeout by default.
+	// Set a timeout on custom clients, the messages signed with a certificate wait for it to be downloaded
+	Client *http.Client
+
+	// Pattern the certificate URL host must match. AWS SNS hosts by default
+	HostPattern *regexp.Regexp
+
+	// certs is protected by mu, which is not held while downloading so a slow certificate URL does not
+	// block the verification of the messages signed with other certificates
+	mu    sync.RWMutex
+	certs map[string]*x509.Certificate
+}
+
+// Certificate returns the certificate served at the given URL
+func (s *HTTPCertificateSource) Certificate(certURL string) (*x509.Certificate, error) {
+	s.mu.RLock()
+	cert, ok := s.certs[certURL]
+	s.mu.RUnlock()
+	if ok {
+		return cert, nil
+	}
+
+	u, err := url.Parse(certURL)
+	if err != nil {
+		return nil, err
+	}
+	hostPattern := s.HostPattern
+	if hostPattern == nil {
+		hostPattern = defaultSNSCertificateHost
+	}
+	if u.Scheme != "https" || !hostPattern.MatchString(u.Hostname()) {
+		return nil, fmt.Errorf("untrusted SNS signing certificate URL %q", certURL)
+	}
+
+	client := s.Client
+	if client == nil {
+		client = defaultCertificateClient
+	}
+	resp, err := client.Get(certURL)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("error when downloading SNS signing certificate %q: %s", certURL, resp.Status)
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(body)
+	if block == nil {
+		return nil, fmt.Errorf("invalid SNS signing certificate %q", certURL)
+	}
+	cert, err = x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.certs == nil {
+		s.certs = make(map[string]*x509.Certificate)
+	}
+	s.certs[certURL] = cert
+	return cert, nil
+}
+
+// snsAttribute is a message attribute as it is delivered in an SNS notification
+type snsAttribute struct {
+	Type  string
+	Value string
+}
+
+// snsEnvelope is the SNS notification delivered to the queues subscribed to a topic without raw message delivery.
+// For more information go to https://docs.aws.amazon.com/sns/latest/dg/sns-message-and-json-formats.html
+type snsEnvelope struct {
+	Type              string
+	MessageId         string
+	TopicArn          string
+	Subject           string
+	Message           *string
+	Timestamp         string
+	SignatureVersion  string
+	Signature         string
+	SigningCertURL    string
+	MessageAttributes map[string]snsAttribute
+
+	timestamp  time.Time
+	attributes map[string]*sqs.MessageAttributeValue
+}
+
+// parseSNSEnvelope returns the SNS notification held by the message body, nil if the body is not an SNS notification
+func parseSNSEnvelope(body string) *snsEnvelope {
+	var e snsEnvelope
+	if err := json.Unmarshal([]byte(body), &e); err != nil {
+		return nil
+	}
+	if e.Type != "Notification" || e.TopicArn == "" || e.Message == nil {
+		return nil
+	}
+
+	e.timestamp, _ = time.Parse(time.RFC3339, e.Timestamp)
+	e.attributes = make(map[string]*sqs.MessageAttributeValue, len(e.MessageAttributes))
+	for name, attr := range e.MessageAttributes {
+		value := &sqs.MessageAttributeValue{DataType: aws.String(attr.Type)}
+		if strings.HasPrefix(attr.Type, "Binary") {
+			value.BinaryValue, _ = base64.StdEncoding.DecodeString(attr.Value)
+		} else {
+			value.StringValue = aws.String(attr.Value)
+		}
+		e.attributes[name] = value
+	}
+	return &e
+}
+
+// verify checks the notification signature against the certificate provided by the given source
+func (e *snsEnvelope) verify(source CertificateSource) error {
+	var hash crypto.Hash
+	switch e.SignatureVersion {
+	case "1":
+		hash = crypto.SHA1
+	case "2":
+		hash = crypto.SHA256
+	default:
+		return fmt.Errorf("%w: unsupported signature version %q", ErrInvalidSNSSignature, e.SignatureVersion)
+	}
+
+	signature, err := base64.StdEncoding.DecodeString(e.Signature)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidSNSSignature, err)
+	}
+
+	cert, err := source.Certificate(e.SigningCertURL)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidSNSSignature, err)
+	}
+	key, ok := cert.PublicKey.(*rsa.PublicKey)
+	if !ok {
+		return fmt.Errorf("%w: unsupported public key type %T", ErrInvalidSNSSignature, cert.PublicKey)
+	}
+
+	if err := rsa.VerifyPKCS1v15(key, hash, e.digest(hash), signature); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidSNSSignature, err)
+	}
+	return nil
+}
+
+// digest returns the hash of the notification fields that are signed by AWS SNS
+func (e *snsEnvelope) digest(hash crypto.Hash) []byte {
+	var b strings.Builder
+	field := func(name, value string) {
+		b.WriteString(name + "\n" + value + "\n")
+	}
+	field("Message", *e.Message)
+	field("MessageId", e.MessageId)
+	if e.Subject != "" {
+		field("Subject", e.Subject)
+	}
+	field("Timestamp", e.Timestamp)
+	field("TopicArn", e.TopicArn)
+	field("Type", e.Type)
+
+	if hash == crypto.SHA1 {
+		sum := sha1.Sum([]byte(b.String()))
+		return sum[:]
+	}
+	sum := sha256.Sum256([]byte(b.String()))
+	return sum[:]
+}
@@ -0,0 +1,246 @@
+package subscriber
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/stretchr/testify/require"
+
+	"github.com/bernardopericacho/htsqs/codec"
+)
+
+// newSigningCertificate returns a self-signed certificate and its key to sign SNS notifications
+func newSigningCertificate(t *testing.T) (*rsa.PrivateKey, *x509.Certificate) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "sns.amazonaws.com"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	return key, cert
+}
+
+// snsNotification returns the body of an SNS notification holding the given message, signed with the given key
+func snsNotification(t *testing.T, key *rsa.PrivateKey, signatureVersion, message string) string {
+	e := &snsEnvelope{
+		Type:             "Notification",
+		MessageId:        "22b80b92-fdea-4c2c-8f9d-bdfb0c7bf324",
+		TopicArn:         "arn:aws:sns:us-west-2:123456789012:MyTopic",
+		Subject:          "My First Message",
+		Message:          &message,
+		Timestamp:        "2012-05-02T00:54:06.655Z",
+		SignatureVersion: signatureVersion,
+		SigningCertURL:   "https://sns.us-west-2.amazonaws.com/SimpleNotificationService.pem",
+		MessageAttributes: map[string]snsAttribute{
+			"event":     {Type: "String", Value: "created"},
+			"signature": {Type: "Binary", Value: "AQI="},
+		},
+	}
+	hash := crypto.SHA1
+	if signatureVersion == "2" {
+		hash = crypto.SHA256
+	}
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, hash, e.digest(hash))
+	require.NoError(t, err)
+	e.Signature = base64.StdEncoding.EncodeToString(signature)
+
+	b, err := json.Marshal(e)
+	require.NoError(t, err)
+	return string(b)
+}
+
+func TestSQSMessageUnwrap(t *testing.T) {
+	key, cert := newSigningCertificate(t)
+	_, otherCert := newSigningCertificate(t)
+
+	tt := []struct {
+		name        string
+		body        string
+		source      CertificateSource
+		unwrapped   bool
+		expectedErr error
+	}{
+		{
+			"Raw message",
+			`{"Type":"Event","Message":"message"}`,
+			nil,
+			false,
+			nil,
+		},
+		{
+			"Not verified",
+			snsNotification(t, key, "1", "message"),
+			nil,
+			true,
+			nil,
+		},
+		{
+			"Signature version 1",
+			snsNotification(t, key, "1", "message"),
+			&certificateSourceMock{cert: cert},
+			true,
+			nil,
+		},
+		{
+			"Signature version 2",
+			snsNotification(t, key, "2", "message"),
+			&certificateSourceMock{cert: cert},
+			true,
+			nil,
+		},
+		{
+			"Unsupported signature version",
+			snsNotification(t, key, "3", "message"),
+			&certificateSourceMock{cert: cert},
+			false,
+			ErrInvalidSNSSignature,
+		},
+		{
+			"Invalid signature",
+			snsNotification(t, key, "1", "message"),
+			&certificateSourceMock{cert: otherCert},
+			false,
+			ErrInvalidSNSSignature,
+		},
+		{
+			"Certificate error",
+			snsNotification(t, key, "1", "message"),
+			&certificateSourceMock{err: errors.New("certificate error")},
+			false,
+			ErrInvalidSNSSignature,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			m := &SQSMessage{rawMessage: &sqs.Message{Body: &tc.body}}
+			err := m.unwrap(tc.source)
+			require.True(t, errors.Is(err, tc.expectedErr))
+
+			if !tc.unwrapped {
+				require.Equal(t, tc.body, string(m.Body()))
+				require.Empty(t, m.TopicArn())
+				require.Empty(t, m.Subject())
+				require.True(t, m.Timestamp().IsZero())
+				return
+			}
+			require.Equal(t, "message", string(m.Body()))
+			require.Equal(t, "arn:aws:sns:us-west-2:123456789012:MyTopic", m.TopicArn())
+			require.Equal(t, "My First Message", m.Subject())
+			require.Equal(t, time.Date(2012, 5, 2, 0, 54, 6, 655000000, time.UTC), m.Timestamp())
+			require.Equal(t, map[string]*sqs.MessageAttributeValue{
+				"event":     {DataType: aws.String("String"), StringValue: aws.String("created")},
+				"signature": {DataType: aws.String("Binary"), BinaryValue: []byte{1, 2}},
+			}, m.MessageAttributes())
+		})
+	}
+}
+
+func TestSQSMessageUnwrapDecode(t *testing.T) {
+	message := base64.StdEncoding.EncodeToString([]byte{0, 1, 2})
+	b, err := json.Marshal(&snsEnvelope{
+		Type:              "Notification",
+		MessageId:         "22b80b92-fdea-4c2c-8f9d-bdfb0c7bf324",
+		TopicArn:          "arn:aws:sns:us-west-2:123456789012:MyTopic",
+		Message:           &message,
+		Timestamp:         "2012-05-02T00:54:06.655Z",
+		MessageAttributes: map[string]snsAttribute{codec.ContentTypeAttribute: {Type: "String", Value: codec.Raw.ContentType()}},
+	})
+	require.NoError(t, err)
+	body := string(b)
+
+	// The content type is read from the attributes of the published message, not the ones of the notification
+	m := &SQSMessage{rawMessage: &sqs.Message{Body: &body}}
+	require.NoError(t, m.unwrap(nil))
+	require.Equal(t, codec.Raw.ContentType(), m.ContentType())
+
+	var decoded []byte
+	require.NoError(t, m.Decode(&decoded))
+	require.Equal(t, []byte{0, 1, 2}, decoded)
+}
+
+func TestHTTPCertificateSource(t *testing.T) {
+	_, cert := newSigningCertificate(t)
+	var requests int32
+	hung := make(chan struct{})
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/hung.pem" {
+			<-hung
+			return
+		}
+		atomic.AddInt32(&requests, 1)
+		require.NoError(t, pem.Encode(w, &pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw}))
+	}))
+	defer server.Close()
+	defer close(hung)
+
+	// Only AWS SNS hosts are trusted by default
+	_, err := (&HTTPCertificateSource{Client: server.Client()}).Certificate(server.URL + "/cert.pem")
+	require.Error(t, err)
+
+	source := &HTTPCertificateSource{Client: server.Client(), HostPattern: regexp.MustCompile(`^127\.0\.0\.1$`)}
+	for i := 0; i < 2; i++ {
+		downloaded, err := source.Certificate(server.URL + "/cert.pem")
+		require.NoError(t, err)
+		require.Equal(t, cert.Raw, downloaded.Raw)
+	}
+	require.Equal(t, int32(1), atomic.LoadInt32(&requests))
+
+	// A hung certificate download does not block the cached certificates
+	go source.Certificate(server.URL + "/hung.pem")
+	time.Sleep(10 * time.Millisecond)
+	downloaded, err := source.Certificate(server.URL + "/cert.pem")
+	require.NoError(t, err)
+	require.Equal(t, cert.Raw, downloaded.Raw)
+
+	// The default client does not wait forever for a certificate
+	require.Equal(t, defaultCertificateTimeout, defaultCertificateClient.Timeout)
+}
+
+func TestSubscriberSNSEnvelope(t *testing.T) {
+	key, cert := newSigningCertificate(t)
+	queue := make(chan *SQSMessage)
+	defer close(queue)
+	subs := New(Config{NumConsumers: 1, UnwrapSNSEnvelope: true, SNSCertificateSource: &certificateSourceMock{cert: cert}})
+	subs.sqs = &sqsMock{queue: queue}
+
+	messages, errCh, err := subs.Consume()
+	require.NoError(t, err)
+
+	go func() {
+		valid := snsNotification(t, key, "2", `{"msg":"message"}`)
+		queue <- &SQSMessage{sub: subs, rawMessage: &sqs.Message{Body: &valid}}
+		// The message is modified after being signed
+		invalid := strings.Replace(valid, "message", "tampered", 1)
+		queue <- &SQSMessage{sub: subs, rawMessage: &sqs.Message{Body: &invalid}}
+	}()
+
+	m := <-messages
+	require.Equal(t, `{"msg":"message"}`, string(m.Body()))
+	require.Equal(t, "arn:aws:sns:us-west-2:123456789012:MyTopic", m.TopicArn())
+	require.True(t, errors.Is(<-errCh, ErrInvalidSNSSignature))
+	require.NoError(t, subs.Stop())
+}
@@ -0,0 +1,55 @@
+package subscriber
+
+import (
+	"sync"
+)
+
+// groupDispatcher runs the handling of messages that belong to the same FIFO message group
+// one after the other, in the order they were dispatched. Messages from different groups
+// are handled concurrently. Once the handling of a message fails, the messages of its group
+// queued after it are skipped so they are not processed before it is delivered again
+type groupDispatcher struct {
+	mu sync.Mutex
+	// pending messages per message group. A group is present while a goroutine is handling its messages
+	groups map[string][]*SQSMessage
+}
+
+func newGroupDispatcher() *groupDispatcher {
+	return &groupDispatcher{groups: make(map[string][]*SQSMessage)}
+}
+
+// dispatch queues the message for handling after all the previously dispatched messages of the same group.
+// handle reports whether the message was handled, skip is called instead of handle for the messages
+// queued after a message whose handling failed
+func (d *groupDispatcher) dispatch(groupID string, m *SQSMessage, handle func(*SQSMessage) bool, skip func(*SQSMessage)) {
+	d.mu.Lock()
+	pending, running := d.groups[groupID]
+	d.groups[groupID] = append(pending, m)
+	d.mu.Unlock()
+
+	if running {
+		return
+	}
+
+	go func() {
+		failed := false
+		for {
+			d.mu.Lock()
+			pending := d.groups[groupID]
+			if len(pending) == 0 {
+				delete(d.groups, groupID)
+				d.mu.Unlock()
+				return
+			}
+			next := pending[0]
+			d.groups[groupID] = pending[1:]
+			d.mu.Unlock()
+
+			if failed {
+				skip(next)
+				continue
+			}
+			failed = !handle(next)
+		}
+	}()
+}
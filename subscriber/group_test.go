@@ -0,0 +1,108 @@
+package subscriber
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGroupDispatcher(t *testing.T) {
+	numMessages := 20
+	groups := []string{"group 1", "group 2", "group 3"}
+	d := newGroupDispatcher()
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	handled := make(map[string][]string)
+	handle := func(m *SQSMessage) bool {
+		defer wg.Done()
+		// Give later messages the chance to overtake the current one
+		time.Sleep(time.Millisecond)
+		mu.Lock()
+		handled[m.MessageGroupID()] = append(handled[m.MessageGroupID()], string(m.Body()))
+		mu.Unlock()
+		return true
+	}
+	skip := func(m *SQSMessage) {
+		defer wg.Done()
+		t.Errorf("message skipped: %s", m.Body())
+	}
+
+	expected := make(map[string][]string)
+	for i := 0; i < numMessages; i++ {
+		for _, group := range groups {
+			group := group
+			body := fmt.Sprintf("Message: %d", i)
+			expected[group] = append(expected[group], body)
+			wg.Add(1)
+			d.dispatch(group, &SQSMessage{rawMessage: &sqs.Message{
+				Body:       &body,
+				Attributes: map[string]*string{sqs.MessageSystemAttributeNameMessageGroupId: &group},
+			}}, handle, skip)
+		}
+	}
+
+	wg.Wait()
+	require.Equal(t, expected, handled)
+
+	// Groups are released once all their messages are handled
+	require.Eventually(t, func() bool {
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		return len(d.groups) == 0
+	}, time.Second, time.Millisecond)
+}
+
+func TestGroupDispatcherFailure(t *testing.T) {
+	d := newGroupDispatcher()
+	message := func(group, body string) *SQSMessage {
+		return &SQSMessage{rawMessage: &sqs.Message{
+			Body:       &body,
+			Attributes: map[string]*string{sqs.MessageSystemAttributeNameMessageGroupId: &group},
+		}}
+	}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	handled := make(map[string][]string)
+	skipped := make(map[string][]string)
+	release := make(chan struct{})
+	handle := func(m *SQSMessage) bool {
+		defer wg.Done()
+		if string(m.Body()) == "a1" {
+			// Wait for the rest of the messages of the group to be queued, then fail
+			<-release
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		handled[m.MessageGroupID()] = append(handled[m.MessageGroupID()], string(m.Body()))
+		return string(m.Body()) != "a1"
+	}
+	skip := func(m *SQSMessage) {
+		defer wg.Done()
+		mu.Lock()
+		defer mu.Unlock()
+		skipped[m.MessageGroupID()] = append(skipped[m.MessageGroupID()], string(m.Body()))
+	}
+
+	for _, m := range []struct{ group, body string }{{"a", "a1"}, {"a", "a2"}, {"b", "b1"}, {"a", "a3"}, {"b", "b2"}} {
+		wg.Add(1)
+		d.dispatch(m.group, message(m.group, m.body), handle, skip)
+	}
+	close(release)
+	wg.Wait()
+
+	// The messages of the group queued after the failed one are skipped, other groups are not affected
+	require.Equal(t, map[string][]string{"a": {"a1"}, "b": {"b1", "b2"}}, handled)
+	require.Equal(t, map[string][]string{"a": {"a2", "a3"}}, skipped)
+
+	// The group is handled again once the failed message is delivered again
+	wg.Add(1)
+	d.dispatch("a", message("a", "a1"), handle, skip)
+	wg.Wait()
+	require.Equal(t, []string{"a1", "a1"}, handled["a"])
+}
@@ -0,0 +1,113 @@
+package subscriber
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+
+	"github.com/bernardopericacho/htsqs/metrics"
+)
+
+const (
+	// maxVisibilityTimeout is the maximum visibility timeout allowed by AWS SQS
+	maxVisibilityTimeout = 12 * time.Hour
+)
+
+// ErrDropMessage is returned by a Handler when the message can never be processed.
+// The message is deleted so it is not delivered again. It can be wrapped to give more details
+var ErrDropMessage = errors.New("drop message")
+
+// Handler processes a SQS message and reports the outcome.
+// The worker deletes the message when the handler returns nil or ErrDropMessage, and dead-letters it
+// when the handler returns ErrDeadLetter or the message reached the worker MaxReceiveCount. Any other error
+// nacks the message: it is delivered again once its visibility timeout expires or, for
+// RetryAfterError errors and when the worker has a RetryPolicy, once the retry delay expires
+type Handler func(context.Context, *SQSMessage) error
+
+// RetryAfterError is returned by a Handler to deliver the message again after the given delay
+type RetryAfterError struct {
+	// Time until the message becomes visible again, up to 12 hours
+	Delay time.Duration
+
+	// Error found when processing the message
+	Err error
+}
+
+// RetryAfter returns an error that makes the message visible again after the given delay
+func RetryAfter(delay time.Duration, err error) error {
+	return &RetryAfterError{Delay: delay, Err: err}
+}
+
+func (e *RetryAfterError) Error() string {
+	return fmt.Sprintf("retry after %s: %v", e.Delay, e.Err)
+}
+
+// Unwrap returns the error found when processing the message
+func (e *RetryAfterError) Unwrap() error {
+	return e.Err
+}
+
+// process runs the worker Handler, wrapped by the middlewares, and acks or nacks the message depending on the returned error.
+// Returns the processing outcome
+func (w *Worker) process(ctx context.Context, m *SQSMessage) metrics.Outcome {
+	err := w.handler(ctx, m)
+	if err != nil && !errors.Is(err, ErrDropMessage) {
+		w.logger().Log(LevelWarn, "Error when processing message", messageFields(m, FieldError, err)...)
+
+		if w.shouldDeadLetter(m, err) {
+			deadLetterErr := w.deadLetter(ctx, m, err)
+			if deadLetterErr == nil {
+				w.done(m)
+				return metrics.OutcomeDeadLettered
+			}
+			w.logger().Log(LevelError, "Error when sending message to the dead-letter publisher", messageFields(m, FieldError, deadLetterErr)...)
+		}
+
+		var delay time.Duration
+		var retryErr *RetryAfterError
+		switch {
+		case errors.As(err, &retryErr):
+			delay = retryErr.Delay
+		case w.config.RetryPolicy != nil:
+			delay = w.config.RetryPolicy.RetryDelay(m, err)
+		default:
+			return metrics.OutcomeNacked
+		}
+
+		// The heartbeat must not extend the visibility once the retry delay is set
+		m.stopHeartbeat()
+		if err := m.ChangeMessageVisibility(visibilityTimeout(delay)); err != nil {
+			w.logger().Log(LevelWarn, "Error when changing message visibility", messageFields(m, FieldError, err)...)
+		}
+		return metrics.OutcomeRetried
+	}
+
+	w.done(m)
+	if err != nil {
+		return metrics.OutcomeDropped
+	}
+	return metrics.OutcomeAcked
+}
+
+// done deletes the message from SQS
+func (w *Worker) done(m *SQSMessage) {
+	if err := m.Done(); err != nil {
+		w.logger().Log(LevelError, "Error when deleting message from SQS", messageFields(m, FieldError, err)...)
+	}
+}
+
+// visibilityTimeout returns the visibility timeout in seconds for the given delay,
+// rounded up and capped to the range allowed by AWS SQS
+func visibilityTimeout(delay time.Duration) *int64 {
+	if delay > maxVisibilityTimeout {
+		delay = maxVisibilityTimeout
+	}
+	if delay < 0 {
+		delay = 0
+	}
+	return aws.Int64(int64(math.Ceil(delay.Seconds())))
+}
@@ -0,0 +1,93 @@
+package subscriber
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWorkerHandler(t *testing.T) {
+
+	tt := []struct {
+		name                      string
+		err                       error
+		expectedDeletes           int32
+		expectedVisibilityChanges int32
+		expectedVisibilityTimeout int64
+	}{
+		{
+			"Processed",
+			nil,
+			1,
+			0,
+			0,
+		},
+		{
+			"Drop message",
+			fmt.Errorf("invalid message: %w", ErrDropMessage),
+			1,
+			0,
+			0,
+		},
+		{
+			"Nack",
+			errors.New("processing error"),
+			0,
+			0,
+			0,
+		},
+		{
+			"Retry after delay",
+			RetryAfter(1500*time.Millisecond, errors.New("processing error")),
+			0,
+			1,
+			2,
+		},
+		{
+			"Retry after delay longer than allowed",
+			RetryAfter(24*time.Hour, errors.New("processing error")),
+			0,
+			1,
+			43200,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			queue := make(chan *SQSMessage)
+			defer close(queue)
+			mock := &sqsMock{queue: queue}
+			subs := New(Config{})
+			subs.sqs = mock
+
+			worker := NewWorker(WorkerConfig{
+				Subscriber: subs,
+				Handler: func(ctx context.Context, m *SQSMessage) error {
+					return tc.err
+				},
+			})
+			handled := dispatched(worker)
+
+			errsChannelStop := make(chan error)
+			go func() {
+				message := fmt.Sprintf("Message")
+				queue <- &SQSMessage{sub: subs, rawMessage: &sqs.Message{Body: &message}}
+				<-handled
+				errsChannelStop <- worker.Stop()
+				close(errsChannelStop)
+			}()
+
+			require.Equal(t, ErrWorkerClosed, worker.Start(context.TODO()))
+			require.NoError(t, <-errsChannelStop)
+			require.Equal(t, tc.expectedDeletes, atomic.LoadInt32(&mock.deletedMessages))
+			require.Equal(t, tc.expectedVisibilityChanges, atomic.LoadInt32(&mock.visibilityChanges))
+			require.Equal(t, tc.expectedVisibilityTimeout, atomic.LoadInt64(&mock.visibilityTimeout))
+		})
+	}
+}
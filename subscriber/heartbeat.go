@@ -0,0 +1,68 @@
+package subscriber
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+const (
+	// defaultMaxVisibilityExtension is the maximum time the visibility of a message can be extended by the heartbeat.
+	// AWS SQS does not allow to extend the visibility timeout beyond 12 hours from the moment the message is received
+	defaultMaxVisibilityExtension = 12 * time.Hour
+)
+
+// heartbeat periodically extends the visibility timeout of an in-flight message
+// so it is not delivered again while it is being processed
+type heartbeat struct {
+	stopOnce sync.Once
+	stop     chan struct{}
+	// closed once the goroutine extending the visibility returns
+	done chan struct{}
+}
+
+// startHeartbeat starts extending the visibility of the message every interval. Each extension hides the message
+// for two intervals, so it does not become visible if an extension is late. Extensions stop when stop is called
+// or once the message has been hidden for maxExtension since it was received
+func startHeartbeat(m *SQSMessage, interval, maxExtension time.Duration, logger Logger) *heartbeat {
+	h := &heartbeat{stop: make(chan struct{}), done: make(chan struct{})}
+	deadline := time.Now().Add(maxExtension)
+
+	go func() {
+		defer close(h.done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-h.stop:
+				return
+			case now := <-ticker.C:
+				extension := 2 * interval
+				if remaining := deadline.Sub(now); remaining < extension {
+					extension = remaining
+				}
+				seconds := int64(math.Ceil(extension.Seconds()))
+				if seconds <= 0 {
+					return
+				}
+				if err := m.ChangeMessageVisibility(aws.Int64(seconds)); err != nil {
+					logger.Log(LevelWarn, "Error when extending the message visibility", messageFields(m, FieldError, err)...)
+				}
+			}
+		}
+	}()
+
+	return h
+}
+
+// Stop stops extending the visibility of the message and waits for an extension in progress, if any,
+// so it does not override a later change of the visibility. It is safe to call it more than once
+func (h *heartbeat) Stop() {
+	h.stopOnce.Do(func() {
+		close(h.stop)
+	})
+	<-h.done
+}
@@ -0,0 +1,130 @@
+package subscriber
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHeartbeat(t *testing.T) {
+	mock := &sqsMock{}
+	subs := New(Config{})
+	subs.sqs = mock
+	body := "Message"
+	m := &SQSMessage{sub: subs, rawMessage: &sqs.Message{Body: &body, ReceiptHandle: &body}}
+
+	m.heartbeat = startHeartbeat(m, 5*time.Millisecond, time.Hour, subs.cfg.Logger)
+	require.Eventually(t, func() bool { return atomic.LoadInt32(&mock.visibilityChanges) >= 3 }, time.Second, time.Millisecond)
+
+	// Visibility is not extended once the message is done
+	require.NoError(t, m.Done())
+	require.NoError(t, m.Done())
+	changes := atomic.LoadInt32(&mock.visibilityChanges)
+	time.Sleep(20 * time.Millisecond)
+	require.Equal(t, changes, atomic.LoadInt32(&mock.visibilityChanges))
+}
+
+func TestHeartbeatMaxExtension(t *testing.T) {
+	mock := &sqsMock{}
+	subs := New(Config{})
+	subs.sqs = mock
+	body := "Message"
+	m := &SQSMessage{sub: subs, rawMessage: &sqs.Message{Body: &body, ReceiptHandle: &body}}
+
+	m.heartbeat = startHeartbeat(m, 5*time.Millisecond, 20*time.Millisecond, subs.cfg.Logger)
+	defer m.stopHeartbeat()
+
+	// Extensions stop once the maximum extension is reached
+	time.Sleep(40 * time.Millisecond)
+	changes := atomic.LoadInt32(&mock.visibilityChanges)
+	require.NotZero(t, changes)
+	time.Sleep(20 * time.Millisecond)
+	require.Equal(t, changes, atomic.LoadInt32(&mock.visibilityChanges))
+}
+
+// blockingVisibilityMock blocks the visibility changes until they are released
+type blockingVisibilityMock struct {
+	*sqsMock
+	changing chan struct{}
+	release  chan struct{}
+}
+
+func (s *blockingVisibilityMock) ChangeMessageVisibilityWithContext(ctx aws.Context, input *sqs.ChangeMessageVisibilityInput, opts ...request.Option) (*sqs.ChangeMessageVisibilityOutput, error) {
+	s.changing <- struct{}{}
+	<-s.release
+	return s.sqsMock.ChangeMessageVisibilityWithContext(ctx, input, opts...)
+}
+
+func TestHeartbeatStopWaitsForExtension(t *testing.T) {
+	mock := &blockingVisibilityMock{sqsMock: &sqsMock{}, changing: make(chan struct{}, 1), release: make(chan struct{})}
+	subs := New(Config{})
+	subs.sqs = mock
+	body := "Message"
+	m := &SQSMessage{sub: subs, rawMessage: &sqs.Message{Body: &body, ReceiptHandle: &body}}
+
+	m.heartbeat = startHeartbeat(m, time.Millisecond, time.Hour, subs.cfg.Logger)
+	<-mock.changing
+
+	// Stop does not return while an extension is in progress
+	stopped := make(chan struct{})
+	go func() {
+		m.stopHeartbeat()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+		t.Fatal("heartbeat stopped during an extension")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	// No extension is made once Stop returns
+	close(mock.release)
+	<-stopped
+	changes := atomic.LoadInt32(&mock.visibilityChanges)
+	require.NotZero(t, changes)
+	time.Sleep(10 * time.Millisecond)
+	require.Equal(t, changes, atomic.LoadInt32(&mock.visibilityChanges))
+}
+
+func TestWorkerHeartbeat(t *testing.T) {
+	queue := make(chan *SQSMessage)
+	defer close(queue)
+	mock := &sqsMock{queue: queue}
+	subs := New(Config{HeartbeatInterval: 5 * time.Millisecond, NumConsumers: 1})
+	subs.sqs = mock
+
+	handled := make(chan struct{})
+	worker := NewWorker(WorkerConfig{
+		Subscriber: subs,
+		MessageHandler: func(ctx context.Context, w *Worker, m *SQSMessage) {
+			// The handler takes longer than a few heartbeats and never calls Done
+			require.Eventually(t, func() bool { return atomic.LoadInt32(&mock.visibilityChanges) >= 3 }, time.Second, time.Millisecond)
+			close(handled)
+		},
+	})
+
+	errsChannelStop := make(chan error)
+	go func() {
+		message := fmt.Sprintf("Message")
+		queue <- &SQSMessage{sub: subs, rawMessage: &sqs.Message{Body: &message}}
+		<-handled
+		errsChannelStop <- worker.Stop()
+		close(errsChannelStop)
+	}()
+
+	require.Equal(t, ErrWorkerClosed, worker.Start(context.TODO()))
+	require.NoError(t, <-errsChannelStop)
+
+	// Visibility is not extended once the handler returns
+	time.Sleep(10 * time.Millisecond)
+	changes := atomic.LoadInt32(&mock.visibilityChanges)
+	time.Sleep(20 * time.Millisecond)
+	require.Equal(t, changes, atomic.LoadInt32(&mock.visibilityChanges))
+}
@@ -0,0 +1,116 @@
+package subscriber
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Level is the severity of a log entry
+type Level int
+
+const (
+	// LevelDebug is used for verbose entries, such as the outcome of every receive request
+	LevelDebug Level = iota
+
+	// LevelInfo is used for entries about the lifecycle of subscribers and workers
+	LevelInfo
+
+	// LevelWarn is used for errors the subscriber or the worker recover from
+	LevelWarn
+
+	// LevelError is used for errors that require attention
+	LevelError
+)
+
+func (l Level) String() string {
+	switch l {
+	case LevelDebug:
+		return "DEBUG"
+	case LevelInfo:
+		return "INFO"
+	case LevelWarn:
+		return "WARN"
+	case LevelError:
+		return "ERROR"
+	default:
+		return fmt.Sprintf("LEVEL(%d)", int(l))
+	}
+}
+
+// Keys of the fields added to the log entries
+const (
+	// FieldQueueURL is the URL of the queue the subscriber consumes from
+	FieldQueueURL = "queue_url"
+
+	// FieldConsumerID is the identifier of the subscriber consumer
+	FieldConsumerID = "consumer_id"
+
+	// FieldMessageID is the identifier of the message
+	FieldMessageID = "message_id"
+
+	// FieldReceiveCount is the number of times the message has been received
+	FieldReceiveCount = "receive_count"
+
+	// FieldError is the error found
+	FieldError = "error"
+)
+
+// Logger is the structured, leveled logger subscribers and workers log through.
+// Fields are given as alternating keys and values, like log/slog does
+type Logger interface {
+	Log(level Level, msg string, keyvals ...interface{})
+}
+
+// PrintfLogger is the interface of the loggers with a Printf method, such as the standard log.Logger
+type PrintfLogger interface {
+	Printf(string, ...interface{})
+}
+
+// NewPrintfLogger returns a Logger writing the entries of the given level or above to a Printf logger,
+// in the "LEVEL message key=value" format
+func NewPrintfLogger(logger PrintfLogger, level Level) Logger {
+	return &printfLogger{logger: logger, level: level}
+}
+
+type printfLogger struct {
+	logger PrintfLogger
+	level  Level
+}
+
+func (l *printfLogger) Log(level Level, msg string, keyvals ...interface{}) {
+	if level < l.level {
+		return
+	}
+
+	var b strings.Builder
+	b.WriteString(level.String())
+	b.WriteString(" ")
+	b.WriteString(msg)
+	for i := 0; i < len(keyvals); i += 2 {
+		if i+1 == len(keyvals) {
+			fmt.Fprintf(&b, " !BADKEY=%v", keyvals[i])
+			break
+		}
+		fmt.Fprintf(&b, " %v=%v", keyvals[i], keyvals[i+1])
+	}
+	l.logger.Printf("%s", b.String())
+}
+
+// withFields returns a Logger adding the given fields to every entry
+func withFields(logger Logger, keyvals ...interface{}) Logger {
+	return &fieldsLogger{logger: logger, keyvals: keyvals}
+}
+
+type fieldsLogger struct {
+	logger  Logger
+	keyvals []interface{}
+}
+
+func (l *fieldsLogger) Log(level Level, msg string, keyvals ...interface{}) {
+	l.logger.Log(level, msg, append(append([]interface{}(nil), l.keyvals...), keyvals...)...)
+}
+
+// messageFields returns the fields identifying the message
+func messageFields(m *SQSMessage, keyvals ...interface{}) []interface{} {
+	return append([]interface{}{FieldMessageID, m.MessageID(), FieldReceiveCount, m.ReceiveCount()}, keyvals...)
+}
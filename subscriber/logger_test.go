@@ -0,0 +1,35 @@
+package subscriber
+
+import (
+	"bytes"
+	"errors"
+	"log"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPrintfLogger(t *testing.T) {
+	var buf bytes.Buffer
+	logger := NewPrintfLogger(log.New(&buf, "", 0), LevelInfo)
+
+	logger.Log(LevelDebug, "Messages received", "count", 0)
+	require.Empty(t, buf.String())
+
+	logger.Log(LevelWarn, "Error when processing message", FieldMessageID, "id", FieldError, errors.New("processing error"))
+	require.Equal(t, "WARN Error when processing message message_id=id error=processing error\n", buf.String())
+
+	buf.Reset()
+	logger.Log(LevelError, "Odd fields", FieldMessageID)
+	require.Equal(t, "ERROR Odd fields !BADKEY=message_id\n", buf.String())
+}
+
+func TestFieldsLogger(t *testing.T) {
+	var buf bytes.Buffer
+	logger := withFields(NewPrintfLogger(log.New(&buf, "", 0), LevelDebug), FieldQueueURL, "myQueueURL")
+
+	logger.Log(LevelInfo, "Consumer listening for messages", FieldConsumerID, 1)
+	logger.Log(LevelInfo, "SQS subscriber listening for messages")
+	require.Equal(t, "INFO Consumer listening for messages queue_url=myQueueURL consumer_id=1\n"+
+		"INFO SQS subscriber listening for messages queue_url=myQueueURL\n", buf.String())
+}
@@ -1,38 +1,293 @@
 package subscriber
 
 import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/sqs"
+
+	"github.com/bernardopericacho/htsqs/blobstore"
+	"github.com/bernardopericacho/htsqs/codec"
+	"github.com/bernardopericacho/htsqs/compression"
+	"github.com/bernardopericacho/htsqs/encryption"
 )
 
 // SQSMessage is the implementation of a SQS message
 type SQSMessage struct {
 	sub        *Subscriber
 	rawMessage *sqs.Message
+	heartbeat  *heartbeat
+	// SNS notification the message was unwrapped from, nil if it was not delivered by SNS
+	envelope *snsEnvelope
+	// body fetched from the BlobStore, decrypted or decompressed, nil unless the message is offloaded, encrypted or compressed
+	body []byte
+	// key of the payload in the BlobStore, empty unless the message is a pointer to an offloaded payload
+	payloadKey string
+	// reports whether the body was decrypted
+	decrypted bool
+}
+
+// MessageID returns the identifier assigned by AWS SQS to the message.
+// Empty for messages that were not received from AWS SQS
+func (m *SQSMessage) MessageID() string {
+	if m.rawMessage == nil {
+		return ""
+	}
+	return aws.StringValue(m.rawMessage.MessageId)
 }
 
-// Body returns the body of the SQS message in bytes
+// Body returns the body of the SQS message in bytes.
+// Returns the published message when the message is an unwrapped SNS notification,
+// the offloaded payload when the message is a pointer to a payload fetched from the BlobStore
+// and the decrypted and decompressed body when the message is encrypted or compressed
 func (m *SQSMessage) Body() []byte {
+	if m.body != nil {
+		return m.body
+	}
+	if m.envelope != nil {
+		return []byte(*m.envelope.Message)
+	}
 	return []byte(*m.rawMessage.Body)
 }
 
-// MessageAttributes returns the message attributes
+// ContentType returns the content type of the message body, set by the publisher codec.
+// Messages without the content-type attribute are JSON encoded
+func (m *SQSMessage) ContentType() string {
+	if value, ok := m.MessageAttributes()[codec.ContentTypeAttribute]; ok && value != nil && value.StringValue != nil {
+		return *value.StringValue
+	}
+	return codec.JSON.ContentType()
+}
+
+// Payload returns the encoded message body, base64 decoded when the codec of its content type is binary.
+// Returns an error if there is no codec registered for the content type of the message
+func (m *SQSMessage) Payload() ([]byte, error) {
+	c, err := codec.Lookup(m.ContentType())
+	if err != nil {
+		return nil, err
+	}
+	if c.Binary() {
+		return base64.StdEncoding.DecodeString(string(m.Body()))
+	}
+	return m.Body(), nil
+}
+
+// Decode decodes the message body with the codec registered for its content type
+// and stores the result in the value pointed to by v
+func (m *SQSMessage) Decode(v interface{}) error {
+	c, err := codec.Lookup(m.ContentType())
+	if err != nil {
+		return err
+	}
+	payload, err := m.Payload()
+	if err != nil {
+		return err
+	}
+	return c.Unmarshal(payload, v)
+}
+
+// MessageAttributes returns the message attributes.
+// Returns the attributes of the published message when the message is an unwrapped SNS notification
 func (m *SQSMessage) MessageAttributes() map[string]*sqs.MessageAttributeValue {
+	if m.envelope != nil {
+		return m.envelope.attributes
+	}
 	return m.rawMessage.MessageAttributes
 }
 
-// Done deletes the message from SQS.
+// TopicArn returns the ARN of the topic the message was published to.
+// Empty unless the message is an unwrapped SNS notification
+func (m *SQSMessage) TopicArn() string {
+	if m.envelope != nil {
+		return m.envelope.TopicArn
+	}
+	return ""
+}
+
+// Subject returns the subject of the SNS notification.
+// Empty unless the message is an unwrapped SNS notification published with a subject
+func (m *SQSMessage) Subject() string {
+	if m.envelope != nil {
+		return m.envelope.Subject
+	}
+	return ""
+}
+
+// Timestamp returns the time the message was published to the topic.
+// Zero unless the message is an unwrapped SNS notification
+func (m *SQSMessage) Timestamp() time.Time {
+	if m.envelope != nil {
+		return m.envelope.timestamp
+	}
+	return time.Time{}
+}
+
+// unwrap exposes the published message when the message is an SNS notification,
+// verifying the notification signature if a certificate source is given
+func (m *SQSMessage) unwrap(source CertificateSource) error {
+	e := parseSNSEnvelope(aws.StringValue(m.rawMessage.Body))
+	if e == nil {
+		return nil
+	}
+	if source != nil {
+		if err := e.verify(source); err != nil {
+			return err
+		}
+	}
+	m.envelope = e
+	return nil
+}
+
+// fetchPayload fetches the offloaded payload from the store when the message is a pointer to it
+func (m *SQSMessage) fetchPayload(ctx context.Context, store blobstore.BlobStore) error {
+	if _, ok := m.MessageAttributes()[blobstore.PayloadSizeAttribute]; !ok {
+		return nil
+	}
+	p, err := blobstore.ParsePointer(string(m.Body()))
+	if err != nil {
+		return err
+	}
+	payload, err := store.Get(ctx, p.Key)
+	if err != nil {
+		return err
+	}
+	m.body, m.payloadKey = payload, p.Key
+	return nil
+}
+
+// decrypt decrypts the body when the message has the encryption key ID attribute
+func (m *SQSMessage) decrypt(ctx context.Context, p encryption.KeyProvider) error {
+	attributes := m.MessageAttributes()
+	keyID, ok := attributes[encryption.KeyIDAttribute]
+	if !ok || keyID == nil || keyID.StringValue == nil {
+		return nil
+	}
+	encryptedKey, ok := attributes[encryption.EncryptedKeyAttribute]
+	if !ok || encryptedKey == nil {
+		return fmt.Errorf("missing %s attribute", encryption.EncryptedKeyAttribute)
+	}
+	b, err := base64.StdEncoding.DecodeString(string(m.Body()))
+	if err != nil {
+		return err
+	}
+	body, err := encryption.Decrypt(ctx, p, *keyID.StringValue, encryptedKey.BinaryValue, b)
+	if err != nil {
+		return err
+	}
+	m.body, m.decrypted = body, true
+	return nil
+}
+
+// decompress decompresses the body when the message has the content-encoding attribute.
+// Encrypted bodies are left as they are until they are decrypted
+func (m *SQSMessage) decompress() error {
+	attributes := m.MessageAttributes()
+	if _, encrypted := attributes[encryption.KeyIDAttribute]; encrypted && !m.decrypted {
+		return nil
+	}
+	value, ok := attributes[compression.ContentEncodingAttribute]
+	if !ok || value == nil || value.StringValue == nil {
+		return nil
+	}
+	c, err := compression.Lookup(*value.StringValue)
+	if err != nil {
+		return err
+	}
+	b, err := base64.StdEncoding.DecodeString(string(m.Body()))
+	if err != nil {
+		return err
+	}
+	body, err := c.Decompress(b)
+	if err != nil {
+		return err
+	}
+	m.body = body
+	return nil
+}
+
+// deletePayload deletes the offloaded payload from the BlobStore, if the subscriber is configured to do so
+func (m *SQSMessage) deletePayload(ctx context.Context) error {
+	if m.payloadKey == "" || !m.sub.cfg.DeleteOffloadedPayloads {
+		return nil
+	}
+	if err := m.sub.cfg.BlobStore.Delete(ctx, m.payloadKey); err != nil {
+		return fmt.Errorf("error when deleting the payload of message %s: %w", m.MessageID(), err)
+	}
+	return nil
+}
+
+// MessageGroupID returns the message group ID of messages received from a FIFO queue.
+// Empty for messages received from standard queues
+func (m *SQSMessage) MessageGroupID() string {
+	return m.attribute(sqs.MessageSystemAttributeNameMessageGroupId)
+}
+
+// MessageDeduplicationID returns the deduplication ID of messages received from a FIFO queue.
+// Empty for messages received from standard queues
+func (m *SQSMessage) MessageDeduplicationID() string {
+	return m.attribute(sqs.MessageSystemAttributeNameMessageDeduplicationId)
+}
+
+// SequenceNumber returns the sequence number assigned by AWS SQS to messages received from a FIFO queue.
+// Empty for messages received from standard queues
+func (m *SQSMessage) SequenceNumber() string {
+	return m.attribute(sqs.MessageSystemAttributeNameSequenceNumber)
+}
+
+// ReceiveCount returns the number of times the message has been received from the queue without being deleted
+func (m *SQSMessage) ReceiveCount() int {
+	count, _ := strconv.Atoi(m.attribute(sqs.MessageSystemAttributeNameApproximateReceiveCount))
+	return count
+}
+
+// attribute returns the value of the given system attribute, empty if the attribute is not set
+func (m *SQSMessage) attribute(name string) string {
+	if value, ok := m.rawMessage.Attributes[name]; ok && value != nil {
+		return *value
+	}
+	return ""
+}
+
+// Done deletes the message from SQS, and its offloaded payload from the BlobStore when DeleteOffloadedPayloads is set.
+// Stops extending the message visibility when the heartbeat is enabled.
+// When the subscriber deletes messages in batches, the message is queued for deletion
+// and deletion errors are sent to the subscriber error channel
 func (m *SQSMessage) Done() error {
+	return m.DoneWithContext(context.Background())
+}
+
+// DoneWithContext is the same as Done with the addition of the ability to pass a context.
+// The context is not used when the message is queued for a batch deletion
+func (m *SQSMessage) DoneWithContext(ctx context.Context) error {
+	m.stopHeartbeat()
+	if m.sub.acker != nil && m.sub.acker.add(m) {
+		return nil
+	}
+
 	deleteParams := &sqs.DeleteMessageInput{
 		QueueUrl:      &m.sub.cfg.SqsQueueURL,
 		ReceiptHandle: m.rawMessage.ReceiptHandle,
 	}
-	_, err := m.sub.sqs.DeleteMessage(deleteParams)
-	return err
+	_, err := m.sub.sqs.DeleteMessageWithContext(ctx, deleteParams)
+	m.sub.cfg.Metrics.Ack(m.sub.cfg.SqsQueueURL, err)
+	if err != nil {
+		return err
+	}
+	return m.deletePayload(ctx)
 }
 
 // ChangeMessageVisibility modifies current message visibility timeout to the one specified in the parameters.
 // This is normally useful when the message processing is taking more time than the default visibility timeout
 func (m *SQSMessage) ChangeMessageVisibility(newVisibilityTimeout *int64) error {
+	return m.ChangeMessageVisibilityWithContext(context.Background(), newVisibilityTimeout)
+}
+
+// ChangeMessageVisibilityWithContext is the same as ChangeMessageVisibility with the addition of the ability to pass a context
+func (m *SQSMessage) ChangeMessageVisibilityWithContext(ctx context.Context, newVisibilityTimeout *int64) error {
 	changeVisibilityParams := &sqs.ChangeMessageVisibilityInput{
 		QueueUrl:          &m.sub.cfg.SqsQueueURL,
 		ReceiptHandle:     m.rawMessage.ReceiptHandle,
@@ -44,6 +299,14 @@ func (m *SQSMessage) ChangeMessageVisibility(newVisibilityTimeout *int64) error
 		return err
 	}
 
-	_, err := m.sub.sqs.ChangeMessageVisibility(changeVisibilityParams)
+	_, err := m.sub.sqs.ChangeMessageVisibilityWithContext(ctx, changeVisibilityParams)
+	m.sub.cfg.Metrics.VisibilityChange(m.sub.cfg.SqsQueueURL, err)
 	return err
 }
+
+// stopHeartbeat stops extending the message visibility, if the heartbeat is enabled
+func (m *SQSMessage) stopHeartbeat() {
+	if m.heartbeat != nil {
+		m.heartbeat.Stop()
+	}
+}
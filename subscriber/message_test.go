@@ -0,0 +1,249 @@
+package subscriber
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/stretchr/testify/require"
+
+	"github.com/bernardopericacho/htsqs/blobstore"
+	"github.com/bernardopericacho/htsqs/codec"
+	"github.com/bernardopericacho/htsqs/compression"
+	"github.com/bernardopericacho/htsqs/encryption"
+)
+
+func TestSQSMessageDecode(t *testing.T) {
+
+	tt := []struct {
+		name                string
+		body                string
+		contentType         *string
+		expectedContentType string
+		expectedPayload     string
+		expectedErr         string
+	}{
+		{
+			"JSON",
+			`"message"`,
+			nil,
+			"application/json",
+			`"message"`,
+			"",
+		},
+		{
+			"Binary",
+			"bWVzc2FnZQ==",
+			aws.String("application/octet-stream"),
+			"application/octet-stream",
+			"message",
+			"",
+		},
+		{
+			"Invalid base64",
+			"message",
+			aws.String("application/octet-stream"),
+			"application/octet-stream",
+			"",
+			"illegal base64 data at input byte 4",
+		},
+		{
+			"Unknown content type",
+			"message",
+			aws.String("text/plain"),
+			"text/plain",
+			"",
+			`no codec registered for content type "text/plain"`,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			m := &SQSMessage{rawMessage: &sqs.Message{Body: &tc.body}}
+			if tc.contentType != nil {
+				m.rawMessage.MessageAttributes = map[string]*sqs.MessageAttributeValue{
+					codec.ContentTypeAttribute: {DataType: aws.String("String"), StringValue: tc.contentType},
+				}
+			}
+			require.Equal(t, tc.expectedContentType, m.ContentType())
+			require.Equal(t, tc.body, string(m.Body()))
+
+			payload, err := m.Payload()
+			var decoded string
+			decodeErr := m.Decode(&decoded)
+			if tc.expectedErr != "" {
+				require.EqualError(t, err, tc.expectedErr)
+				require.EqualError(t, decodeErr, tc.expectedErr)
+				return
+			}
+			require.NoError(t, err)
+			require.NoError(t, decodeErr)
+			require.Equal(t, tc.expectedPayload, string(payload))
+			require.Equal(t, "message", decoded)
+		})
+	}
+}
+
+func TestSQSMessageBlobStore(t *testing.T) {
+	store := &blobStoreMock{blobs: map[string][]byte{"key": []byte(`{"msg":"message"}`)}}
+	queue := make(chan *SQSMessage)
+	defer close(queue)
+	subs := New(Config{NumConsumers: 1, BlobStore: store, DeleteOffloadedPayloads: true})
+	mock := &sqsMock{queue: queue}
+	subs.sqs = mock
+
+	messages, errCh, err := subs.Consume()
+	require.NoError(t, err)
+
+	javaPointer := `["software.amazon.payloadoffloading.PayloadS3Pointer",{"s3BucketName":"bucket","s3Key":"key"}]`
+	pointer := func(key string) *SQSMessage {
+		return &SQSMessage{sub: subs, rawMessage: &sqs.Message{
+			Body: aws.String(blobstore.Pointer{Key: key}.Marshal()),
+			MessageAttributes: map[string]*sqs.MessageAttributeValue{
+				blobstore.PayloadSizeAttribute: {DataType: aws.String("Number"), StringValue: aws.String("17")},
+			},
+		}}
+	}
+	go func() {
+		queue <- pointer("key")
+		queue <- pointer("missing")
+		queue <- &SQSMessage{sub: subs, rawMessage: &sqs.Message{Body: aws.String(`{"msg":"inline"}`)}}
+		queue <- &SQSMessage{sub: subs, rawMessage: &sqs.Message{
+			Body: aws.String(javaPointer),
+			MessageAttributes: map[string]*sqs.MessageAttributeValue{
+				"ExtendedPayloadSize": {DataType: aws.String("Number"), StringValue: aws.String("17")},
+			},
+		}}
+	}()
+
+	m := <-messages
+	require.Equal(t, `{"msg":"message"}`, string(m.Body()))
+	require.True(t, errors.Is(<-errCh, blobstore.ErrNotFound))
+
+	// Messages that are not pointers are left untouched
+	inline := <-messages
+	require.Equal(t, `{"msg":"inline"}`, string(inline.Body()))
+	require.NoError(t, inline.Done())
+
+	// Pointers of the Amazon SQS Extended Client Library are not fetched
+	require.Equal(t, javaPointer, string((<-messages).Body()))
+
+	// The payload is deleted along with the message
+	require.NoError(t, m.Done())
+	require.Empty(t, store.blobs)
+	require.NoError(t, subs.Stop())
+}
+
+func TestSQSMessageDecompress(t *testing.T) {
+	compressed, err := compression.Gzip.Compress([]byte(`{"msg":"message"}`))
+	require.NoError(t, err)
+
+	tt := []struct {
+		name         string
+		body         string
+		encoding     *string
+		expectedBody string
+		expectedErr  string
+	}{
+		{
+			"Not compressed",
+			`{"msg":"message"}`,
+			nil,
+			`{"msg":"message"}`,
+			"",
+		},
+		{
+			"Gzip",
+			base64.StdEncoding.EncodeToString(compressed),
+			aws.String("gzip"),
+			`{"msg":"message"}`,
+			"",
+		},
+		{
+			"Invalid base64",
+			"message",
+			aws.String("gzip"),
+			"",
+			"illegal base64 data at input byte 4",
+		},
+		{
+			"Unknown encoding",
+			"message",
+			aws.String("br"),
+			"",
+			`no compressor registered for content encoding "br"`,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			m := &SQSMessage{rawMessage: &sqs.Message{Body: &tc.body}}
+			if tc.encoding != nil {
+				m.rawMessage.MessageAttributes = map[string]*sqs.MessageAttributeValue{
+					compression.ContentEncodingAttribute: {DataType: aws.String("String"), StringValue: tc.encoding},
+				}
+			}
+			err := m.decompress()
+			if tc.expectedErr != "" {
+				require.EqualError(t, err, tc.expectedErr)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.expectedBody, string(m.Body()))
+
+			var decoded struct{ Msg string }
+			require.NoError(t, m.Decode(&decoded))
+			require.Equal(t, "message", decoded.Msg)
+		})
+	}
+}
+
+func TestSQSMessageDecrypt(t *testing.T) {
+	key := bytes.Repeat([]byte{1}, 32)
+	before, err := encryption.NewStaticKeyProvider("old", map[string][]byte{"old": key})
+	require.NoError(t, err)
+	after, err := encryption.NewStaticKeyProvider("new", map[string][]byte{"old": key, "new": bytes.Repeat([]byte{2}, 32)})
+	require.NoError(t, err)
+
+	compressed, err := compression.Gzip.Compress([]byte(`{"msg":"message"}`))
+	require.NoError(t, err)
+	encrypted := func(p encryption.KeyProvider, body string, compressed bool) *SQSMessage {
+		b, dataKey, err := encryption.Encrypt(context.TODO(), p, []byte(body))
+		require.NoError(t, err)
+		m := &SQSMessage{rawMessage: &sqs.Message{
+			Body: aws.String(base64.StdEncoding.EncodeToString(b)),
+			MessageAttributes: map[string]*sqs.MessageAttributeValue{
+				encryption.KeyIDAttribute:        {DataType: aws.String("String"), StringValue: aws.String(dataKey.KeyID)},
+				encryption.EncryptedKeyAttribute: {DataType: aws.String("Binary"), BinaryValue: dataKey.Encrypted},
+			},
+		}}
+		if compressed {
+			m.rawMessage.MessageAttributes[compression.ContentEncodingAttribute] = &sqs.MessageAttributeValue{DataType: aws.String("String"), StringValue: aws.String("gzip")}
+		}
+		return m
+	}
+
+	// Messages encrypted before the key rotation are decrypted
+	m := encrypted(before, `{"msg":"message"}`, false)
+	require.NoError(t, m.decrypt(context.TODO(), after))
+	require.Equal(t, `{"msg":"message"}`, string(m.Body()))
+
+	m = encrypted(after, base64.StdEncoding.EncodeToString(compressed), true)
+	// Encrypted bodies are not decompressed until they are decrypted
+	require.NoError(t, m.decompress())
+	require.NoError(t, m.decrypt(context.TODO(), after))
+	require.NoError(t, m.decompress())
+	require.Equal(t, `{"msg":"message"}`, string(m.Body()))
+
+	m = encrypted(after, `{"msg":"message"}`, false)
+	require.True(t, errors.Is(m.decrypt(context.TODO(), before), encryption.ErrUnknownKey))
+
+	// Messages that are not encrypted are left untouched
+	m = &SQSMessage{rawMessage: &sqs.Message{Body: aws.String(`{"msg":"message"}`)}}
+	require.NoError(t, m.decrypt(context.TODO(), after))
+	require.Equal(t, `{"msg":"message"}`, string(m.Body()))
+}
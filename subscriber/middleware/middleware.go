@@ -0,0 +1,55 @@
+// Package middleware provides built-in middlewares for the subscriber Worker.
+// Middlewares wrap the processing of each message, like http.Handler middlewares do with requests:
+//
+//	worker := subscriber.NewWorker(subscriber.WorkerConfig{
+//		Subscriber:  subs,
+//		Handler:     handler,
+//		Middlewares: []func(subscriber.Handler) subscriber.Handler{middleware.Logging(logger), middleware.Timeout(time.Minute)},
+//	})
+package middleware
+
+import (
+	"context"
+	"time"
+
+	"github.com/bernardopericacho/htsqs/subscriber"
+)
+
+// Chain returns a middleware applying all the given middlewares in order: the first one is the outermost one
+func Chain(middlewares ...func(subscriber.Handler) subscriber.Handler) func(subscriber.Handler) subscriber.Handler {
+	return func(next subscriber.Handler) subscriber.Handler {
+		for i := len(middlewares) - 1; i >= 0; i-- {
+			next = middlewares[i](next)
+		}
+		return next
+	}
+}
+
+// Logging logs the outcome and the processing time of every message.
+// Processed messages are logged with the Info level and failures with the Warn level
+func Logging(logger subscriber.Logger) func(subscriber.Handler) subscriber.Handler {
+	return func(next subscriber.Handler) subscriber.Handler {
+		return func(ctx context.Context, m *subscriber.SQSMessage) error {
+			start := time.Now()
+			err := next(ctx, m)
+			if err != nil {
+				logger.Log(subscriber.LevelWarn, "Message failed", subscriber.FieldMessageID, m.MessageID(), "duration", time.Since(start), subscriber.FieldError, err)
+				return err
+			}
+			logger.Log(subscriber.LevelInfo, "Message processed", subscriber.FieldMessageID, m.MessageID(), "duration", time.Since(start))
+			return nil
+		}
+	}
+}
+
+// Timeout cancels the context passed to the handler once the given timeout expires.
+// Handlers must honour the context cancellation, the returned error is the one of the handler
+func Timeout(timeout time.Duration) func(subscriber.Handler) subscriber.Handler {
+	return func(next subscriber.Handler) subscriber.Handler {
+		return func(ctx context.Context, m *subscriber.SQSMessage) error {
+			ctx, cancel := context.WithTimeout(ctx, timeout)
+			defer cancel()
+			return next(ctx, m)
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/bernardopericacho/htsqs/subscriber"
+)
+
+// recorder returns a middleware appending its name to calls before and after calling the next handler
+func recorder(name string, calls *[]string) func(subscriber.Handler) subscriber.Handler {
+	return func(next subscriber.Handler) subscriber.Handler {
+		return func(ctx context.Context, m *subscriber.SQSMessage) error {
+			*calls = append(*calls, name)
+			err := next(ctx, m)
+			*calls = append(*calls, name)
+			return err
+		}
+	}
+}
+
+func TestChain(t *testing.T) {
+	var calls []string
+	handler := Chain(recorder("first", &calls), recorder("second", &calls))(func(ctx context.Context, m *subscriber.SQSMessage) error {
+		calls = append(calls, "handler")
+		return nil
+	})
+
+	require.NoError(t, handler(context.TODO(), &subscriber.SQSMessage{}))
+	require.Equal(t, []string{"first", "second", "handler", "second", "first"}, calls)
+}
+
+func TestLogging(t *testing.T) {
+	var buf bytes.Buffer
+	logger := subscriber.NewPrintfLogger(log.New(&buf, "", 0), subscriber.LevelInfo)
+
+	handler := Logging(logger)(func(ctx context.Context, m *subscriber.SQSMessage) error {
+		return nil
+	})
+	require.NoError(t, handler(context.TODO(), &subscriber.SQSMessage{}))
+	require.Contains(t, buf.String(), "INFO Message processed message_id= duration=")
+
+	processingErr := errors.New("processing error")
+	handler = Logging(logger)(func(ctx context.Context, m *subscriber.SQSMessage) error {
+		return processingErr
+	})
+	require.Equal(t, processingErr, handler(context.TODO(), &subscriber.SQSMessage{}))
+	require.Contains(t, buf.String(), "WARN Message failed message_id= duration=")
+	require.Contains(t, buf.String(), "error=processing error")
+}
+
+func TestTimeout(t *testing.T) {
+	handler := Timeout(10 * time.Millisecond)(func(ctx context.Context, m *subscriber.SQSMessage) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	require.Equal(t, context.DeadlineExceeded, handler(context.TODO(), &subscriber.SQSMessage{}))
+}
@@ -1,19 +1,51 @@
 package subscriber
 
 import (
+	"context"
+	"crypto/x509"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/service/sqs"
+
+	"github.com/bernardopericacho/htsqs/blobstore"
+	"github.com/bernardopericacho/htsqs/codec"
+	"github.com/bernardopericacho/htsqs/metrics"
+	"github.com/bernardopericacho/htsqs/publisher"
 )
 
 type sqsMock struct {
-	queue      <-chan *SQSMessage
-	errorQueue <-chan error
+	queue             <-chan *SQSMessage
+	errorQueue        <-chan error
+	visibilityChanges int32
+	visibilityTimeout int64
+
+	failingReceiptHandle string
+	batchDeletes         int32
+	deletedMessages      int32
+
+	// long polling makes receive requests wait for a message, an error or the request cancellation
+	longPolling bool
 }
 
-func (s *sqsMock) ReceiveMessage(*sqs.ReceiveMessageInput) (*sqs.ReceiveMessageOutput, error) {
+func (s *sqsMock) ReceiveMessageWithContext(ctx aws.Context, input *sqs.ReceiveMessageInput, opts ...request.Option) (*sqs.ReceiveMessageOutput, error) {
+	if s.longPolling {
+		select {
+		case message := <-s.queue:
+			return s.receivedMessage(message), nil
+		case err := <-s.errorQueue:
+			return nil, err
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
 	select {
 	case message := <-s.queue:
-		stringMessage := string(message.Body())
-		return &sqs.ReceiveMessageOutput{Messages: []*sqs.Message{{Body: &stringMessage, ReceiptHandle: &stringMessage}}}, nil
+		return s.receivedMessage(message), nil
 	case err := <-s.errorQueue:
 		return nil, err
 	default:
@@ -21,10 +53,142 @@ func (s *sqsMock) ReceiveMessage(*sqs.ReceiveMessageInput) (*sqs.ReceiveMessageO
 	}
 }
 
-func (s *sqsMock) DeleteMessage(*sqs.DeleteMessageInput) (*sqs.DeleteMessageOutput, error) {
+func (s *sqsMock) receivedMessage(message *SQSMessage) *sqs.ReceiveMessageOutput {
+	stringMessage := string(message.Body())
+	return &sqs.ReceiveMessageOutput{Messages: []*sqs.Message{{Body: &stringMessage, MessageId: &stringMessage, ReceiptHandle: &stringMessage, Attributes: message.rawMessage.Attributes, MessageAttributes: message.rawMessage.MessageAttributes}}}
+}
+
+func (s *sqsMock) DeleteMessageWithContext(aws.Context, *sqs.DeleteMessageInput, ...request.Option) (*sqs.DeleteMessageOutput, error) {
+	atomic.AddInt32(&s.deletedMessages, 1)
 	return nil, nil
 }
 
-func (s *sqsMock) ChangeMessageVisibility(*sqs.ChangeMessageVisibilityInput) (*sqs.ChangeMessageVisibilityOutput, error) {
+func (s *sqsMock) DeleteMessageBatchWithContext(ctx aws.Context, input *sqs.DeleteMessageBatchInput, opts ...request.Option) (*sqs.DeleteMessageBatchOutput, error) {
+	atomic.AddInt32(&s.batchDeletes, 1)
+	output := &sqs.DeleteMessageBatchOutput{}
+	for _, entry := range input.Entries {
+		if *entry.ReceiptHandle == s.failingReceiptHandle {
+			output.Failed = append(output.Failed, &sqs.BatchResultErrorEntry{Id: entry.Id, Code: aws.String("ReceiptHandleIsInvalid"), Message: aws.String("invalid")})
+			continue
+		}
+		atomic.AddInt32(&s.deletedMessages, 1)
+		output.Successful = append(output.Successful, &sqs.DeleteMessageBatchResultEntry{Id: entry.Id})
+	}
+	return output, nil
+}
+
+func (s *sqsMock) ChangeMessageVisibilityWithContext(ctx aws.Context, input *sqs.ChangeMessageVisibilityInput, opts ...request.Option) (*sqs.ChangeMessageVisibilityOutput, error) {
+	atomic.StoreInt64(&s.visibilityTimeout, *input.VisibilityTimeout)
+	atomic.AddInt32(&s.visibilityChanges, 1)
 	return nil, nil
 }
+
+type publisherMock struct {
+	published chan<- []byte
+	options   chan<- *publisher.Options
+	err       error
+}
+
+func (p *publisherMock) Publish(ctx context.Context, msg interface{}, opts ...publisher.Option) error {
+	if p.err != nil {
+		return p.err
+	}
+	o := publisher.NewOptions(opts...)
+	c := o.Codec
+	if c == nil {
+		c = codec.JSON
+	}
+	b, err := c.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	p.published <- b
+	p.options <- o
+	return nil
+}
+
+type certificateSourceMock struct {
+	cert *x509.Certificate
+	err  error
+}
+
+func (s *certificateSourceMock) Certificate(certURL string) (*x509.Certificate, error) {
+	return s.cert, s.err
+}
+
+type blobStoreMock struct {
+	mu    sync.Mutex
+	blobs map[string][]byte
+}
+
+func (s *blobStoreMock) Put(ctx context.Context, key string, data []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.blobs[key] = data
+	return nil
+}
+
+func (s *blobStoreMock) Get(ctx context.Context, key string) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	data, ok := s.blobs[key]
+	if !ok {
+		return nil, blobstore.ErrNotFound
+	}
+	return data, nil
+}
+
+func (s *blobStoreMock) Delete(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.blobs, key)
+	return nil
+}
+
+type metricsMock struct {
+	mu                sync.Mutex
+	receives          int
+	messagesReceived  int
+	acks              int
+	visibilityChanges int
+	outcomes          []metrics.Outcome
+}
+
+func (m *metricsMock) Receive(queue string, consumer int, messages int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.receives++
+	m.messagesReceived += messages
+}
+
+func (m *metricsMock) Ack(queue string, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.acks++
+}
+
+func (m *metricsMock) VisibilityChange(queue string, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.visibilityChanges++
+}
+
+func (m *metricsMock) Handle(queue string, outcome metrics.Outcome, duration time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.outcomes = append(m.outcomes, outcome)
+}
+
+func (m *metricsMock) Publish(destination string, duration time.Duration, err error) {}
+
+// dispatched wraps the worker handler to signal every message it is called with. Shutdown drops the received
+// messages that were not dispatched yet, so tests wait for their messages to be dispatched before stopping the worker
+func dispatched(w *Worker) <-chan struct{} {
+	ch := make(chan struct{}, 10)
+	next := w.handler
+	w.handler = func(ctx context.Context, m *SQSMessage) error {
+		ch <- struct{}{}
+		return next(ctx, m)
+	}
+	return ch
+}
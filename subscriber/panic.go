@@ -0,0 +1,103 @@
+package subscriber
+
+import (
+	"context"
+	"fmt"
+	"runtime/debug"
+	"sync"
+	"time"
+)
+
+// PanicError is reported through the worker ErrorHandler when a handler panics while processing a message
+type PanicError struct {
+	// Identifier of the message being processed
+	MessageID string
+
+	// Value passed to panic
+	Value interface{}
+
+	// Stack trace of the goroutine that panicked
+	Stack []byte
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("panic when processing message %s: %v\n%s", e.MessageID, e.Value, e.Stack)
+}
+
+// panicCounter counts the panics of each message until it is processed or quarantined. The panics of a message
+// are forgotten once it does not panic again within the ttl, e.g. when it is processed by another worker, expires
+// or is moved to a dead-letter queue, so the counter does not grow with the messages it never sees again
+type panicCounter struct {
+	mu     sync.Mutex
+	ttl    time.Duration
+	panics map[string]*panicCount
+}
+
+// panicCount is the number of panics of a message and the time of the last one
+type panicCount struct {
+	count int
+	last  time.Time
+}
+
+func newPanicCounter(ttl time.Duration) *panicCounter {
+	return &panicCounter{ttl: ttl, panics: make(map[string]*panicCount)}
+}
+
+// add counts a panic of the given message and returns the number of panics so far.
+// The messages whose last panic is older than the ttl are forgotten
+func (c *panicCounter) add(messageID string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	for id, p := range c.panics {
+		if now.Sub(p.last) > c.ttl {
+			delete(c.panics, id)
+		}
+	}
+
+	p, ok := c.panics[messageID]
+	if !ok {
+		p = &panicCount{}
+		c.panics[messageID] = p
+	}
+	p.count++
+	p.last = now
+	return p.count
+}
+
+// reset forgets the panics of the given message
+func (c *panicCounter) reset(messageID string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.panics, messageID)
+}
+
+// recoverPanic recovers a panic of the handler processing the message and reports it through the ErrorHandler.
+// Messages that panic more than MaxPanics times are quarantined, the rest are delivered again once
+// their visibility timeout expires. Must be deferred
+func (w *Worker) recoverPanic(ctx context.Context, m *SQSMessage) {
+	r := recover()
+	if r == nil {
+		w.panics.reset(m.MessageID())
+		return
+	}
+
+	err := &PanicError{MessageID: m.MessageID(), Value: r, Stack: debug.Stack()}
+	if w.config.MaxPanics > 0 && w.panics.add(m.MessageID()) > w.config.MaxPanics {
+		w.quarantine(ctx, m, err)
+	}
+	w.config.ErrorHandler(ctx, w, err)
+}
+
+// quarantine forwards the message to the quarantine publisher, if any, and deletes it
+func (w *Worker) quarantine(ctx context.Context, m *SQSMessage, err *PanicError) {
+	if w.config.QuarantinePublisher != nil {
+		if err := forward(ctx, w.config.QuarantinePublisher, m, fmt.Errorf("panic: %v", err.Value)); err != nil {
+			w.logger().Log(LevelError, "Error when sending message to the quarantine publisher", messageFields(m, FieldError, err)...)
+			return
+		}
+	}
+	w.panics.reset(m.MessageID())
+	w.done(m)
+}
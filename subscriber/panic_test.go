@@ -0,0 +1,170 @@
+package subscriber
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/stretchr/testify/require"
+
+	"github.com/bernardopericacho/htsqs/publisher"
+)
+
+func TestWorkerPanic(t *testing.T) {
+
+	tt := []struct {
+		name              string
+		maxPanics         int
+		publisher         *publisherMock
+		expectedPublished bool
+		expectedDeletes   int32
+	}{
+		{
+			"Quarantine disabled",
+			0,
+			nil,
+			false,
+			0,
+		},
+		{
+			"Delete",
+			2,
+			nil,
+			false,
+			1,
+		},
+		{
+			"Forward to the quarantine publisher",
+			2,
+			&publisherMock{},
+			true,
+			1,
+		},
+		{
+			"Quarantine publisher error",
+			2,
+			&publisherMock{err: errors.New("publish error")},
+			false,
+			0,
+		},
+		{
+			"Max panics not reached",
+			3,
+			nil,
+			false,
+			0,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			queue := make(chan *SQSMessage)
+			defer close(queue)
+			mock := &sqsMock{queue: queue}
+			subs := New(Config{NumConsumers: 1})
+			subs.sqs = mock
+
+			published := make(chan []byte, 1)
+			options := make(chan *publisher.Options, 1)
+			config := WorkerConfig{
+				Subscriber: subs,
+				Handler: func(ctx context.Context, m *SQSMessage) error {
+					panic("handler panic")
+				},
+				MaxPanics:      tc.maxPanics,
+				MaxConcurrency: 1,
+			}
+			if tc.publisher != nil {
+				tc.publisher.published = published
+				tc.publisher.options = options
+				config.QuarantinePublisher = tc.publisher
+			}
+			var panics []*PanicError
+			config.ErrorHandler = func(ctx context.Context, w *Worker, err error) {
+				var panicErr *PanicError
+				require.True(t, errors.As(err, &panicErr))
+				panics = append(panics, panicErr)
+			}
+			worker := NewWorker(config)
+			handled := dispatched(worker)
+
+			errsChannelStop := make(chan error)
+			go func() {
+				// The same message is received until it is quarantined
+				for i := 0; i < 3; i++ {
+					message := "message"
+					queue <- &SQSMessage{sub: subs, rawMessage: &sqs.Message{Body: &message}}
+					<-handled
+				}
+				errsChannelStop <- worker.Stop()
+				close(errsChannelStop)
+			}()
+
+			require.Equal(t, ErrWorkerClosed, worker.Start(context.TODO()))
+			require.NoError(t, <-errsChannelStop)
+			require.Equal(t, tc.expectedDeletes, atomic.LoadInt32(&mock.deletedMessages))
+
+			require.Len(t, panics, 3)
+			require.Equal(t, "message", panics[0].MessageID)
+			require.Equal(t, "handler panic", panics[0].Value)
+			require.NotEmpty(t, panics[0].Stack)
+
+			if !tc.expectedPublished {
+				require.Empty(t, published)
+				return
+			}
+			require.Equal(t, "message", string(<-published))
+			require.Equal(t, publisher.StringAttribute("panic: handler panic"), (<-options).Attributes[FailureReasonAttribute])
+		})
+	}
+}
+
+func TestWorkerPanicDefaultErrorHandler(t *testing.T) {
+	queue := make(chan *SQSMessage)
+	defer close(queue)
+	subs := New(Config{NumConsumers: 1})
+	subs.sqs = &sqsMock{queue: queue}
+
+	var panics int32
+	worker := NewWorker(WorkerConfig{
+		Subscriber: subs,
+		Handler: func(ctx context.Context, m *SQSMessage) error {
+			atomic.AddInt32(&panics, 1)
+			panic("handler panic")
+		},
+	})
+	errCh := make(chan error, 1)
+	go func() { errCh <- worker.Start(context.TODO()) }()
+
+	for i := 0; i < 3; i++ {
+		message := fmt.Sprintf("message %d", i)
+		queue <- &SQSMessage{sub: subs, rawMessage: &sqs.Message{Body: &message}}
+	}
+	require.Eventually(t, func() bool { return atomic.LoadInt32(&panics) == 3 }, time.Second, time.Millisecond)
+
+	// The panics are only logged, so the worker stops once the handlers return
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	require.NoError(t, worker.Shutdown(ctx))
+	require.Equal(t, ErrWorkerClosed, <-errCh)
+}
+
+func TestPanicCounter(t *testing.T) {
+	c := newPanicCounter(20 * time.Millisecond)
+	require.Equal(t, 1, c.add("message 1"))
+	require.Equal(t, 2, c.add("message 1"))
+	require.Equal(t, 1, c.add("message 2"))
+
+	c.reset("message 2")
+	require.Len(t, c.panics, 1)
+
+	// Messages that do not panic again within the ttl are forgotten
+	time.Sleep(30 * time.Millisecond)
+	require.Equal(t, 1, c.add("message 2"))
+	require.Len(t, c.panics, 1)
+	require.Equal(t, 1, c.add("message 1"))
+}
@@ -0,0 +1,52 @@
+package subscriber
+
+import (
+	"time"
+
+	"github.com/jpillora/backoff"
+)
+
+// RetryPolicy decides when a message whose Handler returned an error is delivered again
+type RetryPolicy interface {
+	// RetryDelay returns the time until the message becomes visible again, up to 12 hours
+	RetryDelay(m *SQSMessage, err error) time.Duration
+}
+
+// ExponentialBackoff is a RetryPolicy that increases the delay exponentially
+// with the number of times the message has been received
+type ExponentialBackoff struct {
+
+	// Delay before the first retry. 1 second by default
+	Min time.Duration
+
+	// Maximum delay. 12 hours by default, the maximum visibility timeout allowed by AWS SQS
+	Max time.Duration
+
+	// Multiplying factor applied to the delay on every retry. 2 by default
+	Factor float64
+
+	// Randomize the delays so messages that failed together are not retried together
+	Jitter bool
+}
+
+// RetryDelay allows ExponentialBackoff to implement the RetryPolicy interface
+func (p ExponentialBackoff) RetryDelay(m *SQSMessage, err error) time.Duration {
+	b := backoff.Backoff{
+		Factor: p.Factor,
+		Jitter: p.Jitter,
+		Min:    p.Min,
+		Max:    p.Max,
+	}
+	if b.Min <= 0 {
+		b.Min = time.Second
+	}
+	if b.Max <= 0 || b.Max > maxVisibilityTimeout {
+		b.Max = maxVisibilityTimeout
+	}
+
+	attempt := m.ReceiveCount() - 1
+	if attempt < 0 {
+		attempt = 0
+	}
+	return b.ForAttempt(float64(attempt))
+}
@@ -0,0 +1,140 @@
+package subscriber
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/stretchr/testify/require"
+)
+
+func messageReceived(count int) *SQSMessage {
+	body := "Message"
+	receiveCount := strconv.Itoa(count)
+	return &SQSMessage{rawMessage: &sqs.Message{
+		Body:       &body,
+		Attributes: map[string]*string{sqs.MessageSystemAttributeNameApproximateReceiveCount: &receiveCount},
+	}}
+}
+
+func TestExponentialBackoff(t *testing.T) {
+
+	tt := []struct {
+		name          string
+		policy        ExponentialBackoff
+		receiveCount  int
+		expectedDelay time.Duration
+	}{
+		{
+			"Defaults first receive",
+			ExponentialBackoff{},
+			1,
+			time.Second,
+		},
+		{
+			"Defaults fourth receive",
+			ExponentialBackoff{},
+			4,
+			8 * time.Second,
+		},
+		{
+			"Defaults capped to 12 hours",
+			ExponentialBackoff{},
+			100,
+			12 * time.Hour,
+		},
+		{
+			"Custom parameters",
+			ExponentialBackoff{Min: 10 * time.Second, Max: time.Minute, Factor: 3},
+			3,
+			time.Minute,
+		},
+		{
+			"Unknown receive count",
+			ExponentialBackoff{Min: 10 * time.Second},
+			0,
+			10 * time.Second,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			require.Equal(t, tc.expectedDelay, tc.policy.RetryDelay(messageReceived(tc.receiveCount), errors.New("processing error")))
+		})
+	}
+}
+
+func TestWorkerRetryPolicy(t *testing.T) {
+	queue := make(chan *SQSMessage)
+	defer close(queue)
+	mock := &sqsMock{queue: queue}
+	subs := New(Config{})
+	subs.sqs = mock
+
+	worker := NewWorker(WorkerConfig{
+		Subscriber: subs,
+		Handler: func(ctx context.Context, m *SQSMessage) error {
+			return errors.New("processing error")
+		},
+		RetryPolicy: ExponentialBackoff{Min: time.Minute},
+	})
+	handled := dispatched(worker)
+
+	errsChannelStop := make(chan error)
+	go func() {
+		message := messageReceived(3)
+		message.sub = subs
+		queue <- message
+		<-handled
+		errsChannelStop <- worker.Stop()
+		close(errsChannelStop)
+	}()
+
+	require.Equal(t, ErrWorkerClosed, worker.Start(context.TODO()))
+	require.NoError(t, <-errsChannelStop)
+	require.Equal(t, int32(0), atomic.LoadInt32(&mock.deletedMessages))
+	require.Equal(t, int32(1), atomic.LoadInt32(&mock.visibilityChanges))
+	require.Equal(t, int64((4 * time.Minute).Seconds()), atomic.LoadInt64(&mock.visibilityTimeout))
+	require.Equal(t, 3, messageReceived(3).ReceiveCount())
+	require.Equal(t, 0, (&SQSMessage{rawMessage: &sqs.Message{}}).ReceiveCount())
+}
+
+func TestWorkerRetryPolicyHeartbeat(t *testing.T) {
+	queue := make(chan *SQSMessage)
+	defer close(queue)
+	mock := &sqsMock{queue: queue}
+	subs := New(Config{HeartbeatInterval: time.Millisecond, NumConsumers: 1})
+	subs.sqs = mock
+
+	worker := NewWorker(WorkerConfig{
+		Subscriber: subs,
+		Handler: func(ctx context.Context, m *SQSMessage) error {
+			// The handler fails while the heartbeat is extending the visibility
+			require.Eventually(t, func() bool { return atomic.LoadInt32(&mock.visibilityChanges) >= 3 }, time.Second, time.Millisecond)
+			return errors.New("processing error")
+		},
+		RetryPolicy: ExponentialBackoff{Min: time.Minute},
+	})
+	handled := dispatched(worker)
+
+	errsChannelStop := make(chan error)
+	go func() {
+		message := messageReceived(1)
+		message.sub = subs
+		queue <- message
+		<-handled
+		errsChannelStop <- worker.Stop()
+		close(errsChannelStop)
+	}()
+
+	require.Equal(t, ErrWorkerClosed, worker.Start(context.TODO()))
+	require.NoError(t, <-errsChannelStop)
+
+	// The retry delay is not overridden by the heartbeat
+	time.Sleep(10 * time.Millisecond)
+	require.Equal(t, int64(time.Minute.Seconds()), atomic.LoadInt64(&mock.visibilityTimeout))
+}
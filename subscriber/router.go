@@ -0,0 +1,129 @@
+package subscriber
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/bernardopericacho/htsqs/publisher"
+)
+
+// ErrUnknownType is returned by the Router when it nacks a message whose type has no handler
+var ErrUnknownType = errors.New("unknown message type")
+
+// UnknownTypePolicy decides the outcome of the messages whose type has no handler when the Router has no fallback
+type UnknownTypePolicy int
+
+const (
+	// NackUnknownType nacks the message, so it is delivered again, or dead-lettered once it reaches the worker
+	// MaxReceiveCount, e.g. while the handlers of a new type are being deployed
+	NackUnknownType UnknownTypePolicy = iota
+
+	// AckUnknownType deletes the message
+	AckUnknownType
+
+	// DeadLetterUnknownType sends the message to the worker dead-letter publisher. Requires the worker to have a
+	// DeadLetterPublisher: without one the message is nacked, as any message whose Handler returns ErrDeadLetter,
+	// and delivered again until the queue redrive policy, if any, moves it to its dead-letter queue
+	DeadLetterUnknownType
+)
+
+// RouterConfig holds the settings of a Router
+type RouterConfig struct {
+
+	// Message attribute holding the message type. The attribute set by the publishers for the messages
+	// implementing publisher.TypedMessage by default
+	TypeAttribute string
+
+	// Field of the JSON message body holding the message type, used when the message has no type attribute.
+	// Nested fields are separated by dots, e.g. metadata.type. Not used when empty
+	TypeField string
+
+	// Handler of the messages whose type has no handler
+	Fallback Handler
+
+	// Outcome of the messages whose type has no handler when there is no Fallback. Nacked by default
+	UnknownType UnknownTypePolicy
+}
+
+// Router dispatches every message to the handler registered for its type. Its Route method is a Handler:
+//
+//	router := subscriber.NewRouter(subscriber.RouterConfig{})
+//	router.Handle("order.created", handleOrderCreated)
+//	worker := subscriber.NewWorker(subscriber.WorkerConfig{Subscriber: subs, Handler: router.Route})
+type Router struct {
+	cfg      RouterConfig
+	mu       sync.RWMutex
+	handlers map[string]Handler
+}
+
+// Handle registers the handler of the messages of the given type, replacing any handler previously registered for it
+func (r *Router) Handle(messageType string, h Handler) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.handlers[messageType] = h
+}
+
+// Route runs the handler registered for the message type, or the fallback handler if there is none.
+// Messages whose type has no handler are nacked, deleted or dead-lettered depending on the UnknownType policy
+func (r *Router) Route(ctx context.Context, m *SQSMessage) error {
+	messageType := r.Type(m)
+	r.mu.RLock()
+	h, ok := r.handlers[messageType]
+	r.mu.RUnlock()
+	if ok {
+		return h(ctx, m)
+	}
+	if r.cfg.Fallback != nil {
+		return r.cfg.Fallback(ctx, m)
+	}
+
+	switch r.cfg.UnknownType {
+	case AckUnknownType:
+		return fmt.Errorf("%w: unknown message type %q", ErrDropMessage, messageType)
+	case DeadLetterUnknownType:
+		return fmt.Errorf("%w: unknown message type %q", ErrDeadLetter, messageType)
+	default:
+		return fmt.Errorf("%w %q", ErrUnknownType, messageType)
+	}
+}
+
+// Type returns the type of the message, read from the type attribute or, when the message has none,
+// from the type field of the JSON body. Empty if the message has no type
+func (r *Router) Type(m *SQSMessage) string {
+	if value, ok := m.MessageAttributes()[r.cfg.TypeAttribute]; ok && value != nil && value.StringValue != nil {
+		return *value.StringValue
+	}
+	if r.cfg.TypeField == "" {
+		return ""
+	}
+
+	data := json.RawMessage(m.Body())
+	for _, field := range strings.Split(r.cfg.TypeField, ".") {
+		var fields map[string]json.RawMessage
+		if err := json.Unmarshal(data, &fields); err != nil {
+			return ""
+		}
+		data = fields[field]
+	}
+	var messageType string
+	if err := json.Unmarshal(data, &messageType); err != nil {
+		return ""
+	}
+	return messageType
+}
+
+func defaultRouterConfig(cfg *RouterConfig) {
+	if cfg.TypeAttribute == "" {
+		cfg.TypeAttribute = publisher.TypeAttribute
+	}
+}
+
+// NewRouter creates a Router without handlers
+func NewRouter(cfg RouterConfig) *Router {
+	defaultRouterConfig(&cfg)
+	return &Router{cfg: cfg, handlers: make(map[string]Handler)}
+}
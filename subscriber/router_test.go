@@ -0,0 +1,151 @@
+package subscriber
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRouter(t *testing.T) {
+	typed := func(messageType, body string) *SQSMessage {
+		m := &SQSMessage{rawMessage: &sqs.Message{Body: aws.String(body)}}
+		if messageType != "" {
+			m.rawMessage.MessageAttributes = map[string]*sqs.MessageAttributeValue{
+				"type": {DataType: aws.String("String"), StringValue: aws.String(messageType)},
+			}
+		}
+		return m
+	}
+	handled := errors.New("handled")
+	fallback := errors.New("fallback")
+
+	tt := []struct {
+		name        string
+		cfg         RouterConfig
+		message     *SQSMessage
+		expectedErr error
+	}{
+		{
+			"Type attribute",
+			RouterConfig{},
+			typed("order.created", `{}`),
+			handled,
+		},
+		{
+			"Type field",
+			RouterConfig{TypeField: "type"},
+			typed("", `{"type":"order.created"}`),
+			handled,
+		},
+		{
+			"Nested type field",
+			RouterConfig{TypeField: "metadata.type"},
+			typed("", `{"metadata":{"type":"order.created"}}`),
+			handled,
+		},
+		{
+			"Type attribute takes precedence",
+			RouterConfig{TypeField: "type"},
+			typed("order.deleted", `{"type":"order.created"}`),
+			ErrUnknownType,
+		},
+		{
+			"Fallback",
+			RouterConfig{Fallback: func(ctx context.Context, m *SQSMessage) error { return fallback }},
+			typed("order.deleted", `{}`),
+			fallback,
+		},
+		{
+			"Nack unknown type",
+			RouterConfig{},
+			typed("", `not json`),
+			ErrUnknownType,
+		},
+		{
+			"Ack unknown type",
+			RouterConfig{UnknownType: AckUnknownType},
+			typed("order.deleted", `{}`),
+			ErrDropMessage,
+		},
+		{
+			"Dead-letter unknown type",
+			RouterConfig{UnknownType: DeadLetterUnknownType},
+			typed("order.deleted", `{}`),
+			ErrDeadLetter,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			router := NewRouter(tc.cfg)
+			router.Handle("order.created", func(ctx context.Context, m *SQSMessage) error {
+				return handled
+			})
+			require.True(t, errors.Is(router.Route(context.TODO(), tc.message), tc.expectedErr))
+		})
+	}
+}
+
+func TestRouterWorker(t *testing.T) {
+	queue := make(chan *SQSMessage)
+	defer close(queue)
+	subs := New(Config{NumConsumers: 1})
+	mock := &sqsMock{queue: queue}
+	subs.sqs = mock
+
+	handled := make(chan string, 1)
+	router := NewRouter(RouterConfig{UnknownType: AckUnknownType})
+	router.Handle("order.created", func(ctx context.Context, m *SQSMessage) error {
+		handled <- string(m.Body())
+		return nil
+	})
+	worker := NewWorker(WorkerConfig{Subscriber: subs, Handler: router.Route})
+	errCh := make(chan error, 1)
+	go func() { errCh <- worker.Start(context.TODO()) }()
+
+	queue <- &SQSMessage{sub: subs, rawMessage: &sqs.Message{
+		Body:              aws.String("unknown"),
+		MessageAttributes: map[string]*sqs.MessageAttributeValue{"type": {DataType: aws.String("String"), StringValue: aws.String("order.deleted")}},
+	}}
+	queue <- &SQSMessage{sub: subs, rawMessage: &sqs.Message{
+		Body:              aws.String("created"),
+		MessageAttributes: map[string]*sqs.MessageAttributeValue{"type": {DataType: aws.String("String"), StringValue: aws.String("order.created")}},
+	}}
+	require.Equal(t, "created", <-handled)
+
+	// Both the unknown and the handled messages are deleted
+	require.Eventually(t, func() bool { return atomic.LoadInt32(&mock.deletedMessages) == 2 }, time.Second, time.Millisecond)
+	require.NoError(t, worker.Stop())
+	require.Equal(t, ErrWorkerClosed, <-errCh)
+}
+
+func TestRouterWorkerDeadLetterWithoutPublisher(t *testing.T) {
+	queue := make(chan *SQSMessage)
+	defer close(queue)
+	subs := New(Config{NumConsumers: 1})
+	mock := &sqsMock{queue: queue}
+	subs.sqs = mock
+
+	router := NewRouter(RouterConfig{UnknownType: DeadLetterUnknownType})
+	worker := NewWorker(WorkerConfig{Subscriber: subs, Handler: router.Route})
+	handled := dispatched(worker)
+	errCh := make(chan error, 1)
+	go func() { errCh <- worker.Start(context.TODO()) }()
+
+	queue <- &SQSMessage{sub: subs, rawMessage: &sqs.Message{
+		Body:              aws.String("unknown"),
+		MessageAttributes: map[string]*sqs.MessageAttributeValue{"type": {DataType: aws.String("String"), StringValue: aws.String("order.deleted")}},
+	}}
+	<-handled
+
+	// Without a dead-letter publisher the message is nacked, so it is not deleted
+	require.NoError(t, worker.Stop())
+	require.Equal(t, ErrWorkerClosed, <-errCh)
+	require.Equal(t, int32(0), atomic.LoadInt32(&mock.deletedMessages))
+}
@@ -0,0 +1,36 @@
+//go:build go1.21
+// +build go1.21
+
+package subscriber
+
+import (
+	"context"
+	"log/slog"
+)
+
+// NewSlogLogger returns a Logger writing the entries to the given log/slog logger
+func NewSlogLogger(logger *slog.Logger) Logger {
+	return &slogLogger{logger: logger}
+}
+
+type slogLogger struct {
+	logger *slog.Logger
+}
+
+func (l *slogLogger) Log(level Level, msg string, keyvals ...interface{}) {
+	l.logger.Log(context.Background(), slogLevel(level), msg, keyvals...)
+}
+
+// slogLevel returns the log/slog level of the given level
+func slogLevel(level Level) slog.Level {
+	switch level {
+	case LevelDebug:
+		return slog.LevelDebug
+	case LevelInfo:
+		return slog.LevelInfo
+	case LevelWarn:
+		return slog.LevelWarn
+	default:
+		return slog.LevelError
+	}
+}
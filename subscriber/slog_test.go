@@ -0,0 +1,31 @@
+//go:build go1.21
+// +build go1.21
+
+package subscriber
+
+import (
+	"bytes"
+	"log/slog"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSlogLogger(t *testing.T) {
+	var buf bytes.Buffer
+	logger := NewSlogLogger(slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{
+		Level: slog.LevelInfo,
+		ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+			if a.Key == slog.TimeKey {
+				return slog.Attr{}
+			}
+			return a
+		},
+	})))
+
+	logger.Log(LevelDebug, "Messages received", "count", 0)
+	require.Empty(t, buf.String())
+
+	logger.Log(LevelWarn, "Error when processing message", FieldMessageID, "id", FieldReceiveCount, 2)
+	require.Equal(t, "level=WARN msg=\"Error when processing message\" message_id=id receive_count=2\n", buf.String())
+}
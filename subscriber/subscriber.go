@@ -1,7 +1,9 @@
 package subscriber
 
 import (
+	"context"
 	"errors"
+	"fmt"
 	"log"
 	"os"
 	"sync"
@@ -9,9 +11,14 @@ import (
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/sqs"
 	"github.com/jpillora/backoff"
+
+	"github.com/bernardopericacho/htsqs/blobstore"
+	"github.com/bernardopericacho/htsqs/encryption"
+	"github.com/bernardopericacho/htsqs/metrics"
 )
 
 const (
@@ -32,24 +39,25 @@ func (b *atomicBool) setTrue() error {
 	return errors.New("value is already set")
 }
 
-// receiver is the interface to sqsiface.SQSAPI. The only purpose is to be able to mock sqs for testing. See mock_test.go
-type receiver interface {
-	ReceiveMessage(*sqs.ReceiveMessageInput) (*sqs.ReceiveMessageOutput, error)
-	DeleteMessage(*sqs.DeleteMessageInput) (*sqs.DeleteMessageOutput, error)
-	ChangeMessageVisibility(params *sqs.ChangeMessageVisibilityInput) (*sqs.ChangeMessageVisibilityOutput, error)
-}
-
-// Logger interface allows to use other loggers than standard log.Logger
-type Logger interface {
-	Printf(string, ...interface{})
+// SQSClient is the subset of sqsiface.SQSAPI used by the subscriber. It allows to consume through
+// a custom client, such as one configured with a custom endpoint or wrapped with instrumentation
+type SQSClient interface {
+	ReceiveMessageWithContext(aws.Context, *sqs.ReceiveMessageInput, ...request.Option) (*sqs.ReceiveMessageOutput, error)
+	DeleteMessageWithContext(aws.Context, *sqs.DeleteMessageInput, ...request.Option) (*sqs.DeleteMessageOutput, error)
+	DeleteMessageBatchWithContext(aws.Context, *sqs.DeleteMessageBatchInput, ...request.Option) (*sqs.DeleteMessageBatchOutput, error)
+	ChangeMessageVisibilityWithContext(aws.Context, *sqs.ChangeMessageVisibilityInput, ...request.Option) (*sqs.ChangeMessageVisibilityOutput, error)
 }
 
 // Config holds the info required to work with Amazon SQS
 type Config struct {
 
-	// AWS session
+	// AWS session. Not used when SQSClient is set
 	AWSSession *session.Session
 
+	// Client used to consume the messages, e.g. a sqsiface.SQSAPI configured to use LocalStack.
+	// Built from AWSSession by default
+	SQSClient SQSClient
+
 	// SQS queue from which the subscriber is going to consume from
 	SqsQueueURL string
 
@@ -67,11 +75,58 @@ type Config struct {
 	// VisibilityTimeout should be < time needed to process a message
 	VisibilityTimeout *int64
 
+	// Interval at which the visibility timeout of the received messages is extended while they are being processed.
+	// The extension stops once the message is done, the Worker handler returns or MaxVisibilityExtension is reached.
+	// Disabled when zero
+	HeartbeatInterval time.Duration
+
+	// Maximum time the visibility of a message can be extended by the heartbeat since it was received,
+	// so messages that can never be processed eventually become visible again. 12 hours by default
+	MaxVisibilityExtension time.Duration
+
+	// Interval at which the messages marked as done are deleted from AWS SQS in batches.
+	// A batch is also deleted as soon as it holds 10 messages. When enabled, SQSMessage.Done
+	// queues the message for deletion and deletion errors are sent to the error channel.
+	// Disabled when zero, messages are deleted one by one
+	AckFlushInterval time.Duration
+
+	// Unwrap the SNS notifications delivered to queues subscribed to a topic without raw message delivery,
+	// so SQSMessage exposes the published message and its attributes. Other messages are left untouched
+	UnwrapSNSEnvelope bool
+
+	// Source of the certificates used to verify the signature of the unwrapped SNS notifications.
+	// Notifications failing the verification are not pushed to the messages channel and an error wrapping
+	// ErrInvalidSNSSignature is sent to the error channel, they become visible again in the queue once their
+	// visibility timeout expires. Signatures are not verified when nil
+	SNSCertificateSource CertificateSource
+
+	// Store the publishers offload the large message payloads to. The payloads of the pointer messages are fetched
+	// before pushing the messages to the messages channel, so SQSMessage.Body returns the published payload.
+	// Messages whose payload can not be fetched are not pushed and the error is sent to the error channel.
+	// Pointer messages are pushed as they are when nil
+	BlobStore blobstore.BlobStore
+
+	// Delete the offloaded payload from the BlobStore once the message is deleted from AWS SQS.
+	// It must not be used when the messages are published to a topic that fans out to several queues:
+	// the queues receive pointers to the same payload, and the subscribers of the other queues fail to fetch it
+	// once the first one deletes it
+	DeleteOffloadedPayloads bool
+
+	// Provider decrypting the data keys of the encrypted messages, so SQSMessage.Body returns the decrypted body.
+	// It must hold the master keys of the publishers, including the ones rotated out while messages encrypted under
+	// them can still be received. Messages that can not be decrypted are not pushed and the error is sent to the error
+	// channel. Encrypted messages are pushed as they are when nil
+	KeyProvider encryption.KeyProvider
+
 	// number of consumers per subscriber
 	NumConsumers int
 
-	// subscriber logger
+	// subscriber and worker logger. Entries of the Info level and above are written to the standard output by default.
+	// Wrap Printf loggers, such as log.Logger, with NewPrintfLogger
 	Logger Logger
+
+	// Metrics recording the activity of the subscriber and its workers. Nothing is recorded by default
+	Metrics metrics.Metrics
 }
 
 // Subscriber is an SQS client that allows a user to
@@ -79,16 +134,29 @@ type Config struct {
 // Once Stop has been called on subscriber, it might not be reused;
 // future calls to methods such as Consume or Stop will return an error.
 type Subscriber struct {
-	sqs      receiver
+	sqs      SQSClient
 	cfg      Config
 	stopped  atomicBool
 	consumed atomicBool
 	stop     chan error
+	// closed when Stop is called to cancel the in-flight receive requests
+	stopping chan struct{}
+	acker    *acker
+	// configured logger adding the queue URL to every entry
+	logger Logger
 }
 
 // Consume starts consuming messages from the SQS queue.
 // Returns a channel of SubscriberMessage to consume them and a channel of errors
 func (s *Subscriber) Consume() (<-chan *SQSMessage, <-chan error, error) {
+	return s.ConsumeWithContext(context.Background())
+}
+
+// ConsumeWithContext is the same as Consume with the addition of the ability to pass a context.
+// Consumers stop receiving messages when the context is canceled or the subscriber is stopped, dropping
+// the received messages that were not pushed to the messages channel yet. Those messages become visible again in the queue
+// once their visibility timeout expires
+func (s *Subscriber) ConsumeWithContext(ctx context.Context) (<-chan *SQSMessage, <-chan error, error) {
 	if s.stopped.isSet() {
 		return nil, nil, errors.New("SQS subscriber is already stopped")
 	}
@@ -110,6 +178,21 @@ func (s *Subscriber) Consume() (<-chan *SQSMessage, <-chan error, error) {
 	messages = make(chan *SQSMessage, messagesPerBatchPerConsumer*int64(s.cfg.NumConsumers))
 	errCh = make(chan error, int64(s.cfg.NumConsumers))
 
+	if s.cfg.AckFlushInterval > 0 {
+		s.acker = newAcker(s, errCh)
+		go s.acker.run(s.cfg.AckFlushInterval)
+	}
+
+	// in-flight receive requests are canceled when the subscriber is stopped
+	receiveCtx, cancelReceive := context.WithCancel(ctx)
+	go func() {
+		select {
+		case <-s.stopping:
+			cancelReceive()
+		case <-receiveCtx.Done():
+		}
+	}()
+
 	backoffCounter := backoff.Backoff{
 		Factor: 1,
 		Min:    time.Second,
@@ -120,14 +203,15 @@ func (s *Subscriber) Consume() (<-chan *SQSMessage, <-chan error, error) {
 	for i := 1; i <= s.cfg.NumConsumers; i++ {
 		wg.Add(1)
 		go func(workerID int, backoffCfg backoff.Backoff) {
-			s.cfg.Logger.Printf("Consumer %d listening for messages", workerID)
+			s.logger.Log(LevelInfo, "Consumer listening for messages", FieldConsumerID, workerID)
 			defer wg.Done()
 
 			var msgs *sqs.ReceiveMessageOutput
 			var err error
 
-			for !s.stopped.isSet() {
-				msgs, err = s.sqs.ReceiveMessage(&sqs.ReceiveMessageInput{
+			for !s.stopped.isSet() && receiveCtx.Err() == nil {
+				msgs, err = s.sqs.ReceiveMessageWithContext(receiveCtx, &sqs.ReceiveMessageInput{
+					AttributeNames:        []*string{aws.String(sqs.QueueAttributeNameAll)},
 					MessageAttributeNames: []*string{aws.String(sqs.QueueAttributeNameAll)},
 					MaxNumberOfMessages:   s.cfg.MaxMessagesPerBatch,
 					QueueUrl:              &s.cfg.SqsQueueURL,
@@ -135,21 +219,68 @@ func (s *Subscriber) Consume() (<-chan *SQSMessage, <-chan error, error) {
 					VisibilityTimeout:     s.cfg.VisibilityTimeout,
 				})
 
+				if receiveCtx.Err() != nil {
+					// The subscriber is stopping, the request was canceled
+					break
+				}
+
 				if err != nil {
 					// Error found, send the error
 					errCh <- err
-					time.Sleep(backoffCfg.Duration())
+					select {
+					case <-time.After(backoffCfg.Duration()):
+					case <-receiveCtx.Done():
+					}
 					continue
 				}
 
-				s.cfg.Logger.Printf("Found %d messages\n", len(msgs.Messages))
+				s.logger.Log(LevelDebug, "Messages received", FieldConsumerID, workerID, "count", len(msgs.Messages))
+				s.cfg.Metrics.Receive(s.cfg.SqsQueueURL, workerID, len(msgs.Messages))
 				backoffCfg.Reset()
-				// for each message, pass to output
+				// for each message, pass to output. Messages are pushed in the order they were received,
+				// AWS SQS does not return messages from a FIFO message group while others from the same group are in flight
 				for _, msg := range msgs.Messages {
-					messages <- &SQSMessage{
+					if receiveCtx.Err() != nil {
+						// The subscriber is stopping, the remaining messages become visible again once their
+						// visibility timeout expires
+						break
+					}
+					m := &SQSMessage{
 						sub:        s,
 						rawMessage: msg,
 					}
+					if s.cfg.UnwrapSNSEnvelope {
+						if err := m.unwrap(s.cfg.SNSCertificateSource); err != nil {
+							errCh <- fmt.Errorf("error when unwrapping message %s: %w", aws.StringValue(msg.MessageId), err)
+							continue
+						}
+					}
+					if s.cfg.BlobStore != nil {
+						if err := m.fetchPayload(ctx, s.cfg.BlobStore); err != nil {
+							errCh <- fmt.Errorf("error when fetching the payload of message %s: %w", aws.StringValue(msg.MessageId), err)
+							continue
+						}
+					}
+					if s.cfg.KeyProvider != nil {
+						if err := m.decrypt(ctx, s.cfg.KeyProvider); err != nil {
+							errCh <- fmt.Errorf("error when decrypting message %s: %w", aws.StringValue(msg.MessageId), err)
+							continue
+						}
+					}
+					if err := m.decompress(); err != nil {
+						errCh <- fmt.Errorf("error when decompressing message %s: %w", aws.StringValue(msg.MessageId), err)
+						continue
+					}
+					if s.cfg.HeartbeatInterval > 0 {
+						m.heartbeat = startHeartbeat(m, s.cfg.HeartbeatInterval, s.cfg.MaxVisibilityExtension, s.logger)
+					}
+					select {
+					case messages <- m:
+					case <-receiveCtx.Done():
+						// Nobody takes the message before the subscriber stops, it becomes visible again
+						// once its visibility timeout expires
+						m.stopHeartbeat()
+					}
 				}
 			}
 		}(i, backoffCounter)
@@ -157,41 +288,73 @@ func (s *Subscriber) Consume() (<-chan *SQSMessage, <-chan error, error) {
 
 	go func() {
 		wg.Wait()
+		cancelReceive()
 		close(messages)
+		// Delete the pending messages before closing the error channel the acker reports to
+		if s.acker != nil {
+			s.acker.close()
+		}
 		close(errCh)
 		s.stop <- nil
 		close(s.stop)
 	}()
 
-	s.cfg.Logger.Printf("SQS subscriber listening for messages\n")
+	s.logger.Log(LevelInfo, "SQS subscriber listening for messages")
 	return messages, errCh, nil
 }
 
 // Stop stop gracefully the Subscriber.
 // Blocks until all consumers from the subscriber are gracefully stopped
+// and all the messages marked as done are deleted
 func (s *Subscriber) Stop() error {
+	return s.StopWithContext(context.Background())
+}
+
+// StopWithContext is the same as Stop with the addition of the ability to pass a context.
+// In-flight receive requests are canceled right away. Returns the context error if the context
+// is done before the subscriber is stopped, the subscriber keeps stopping in the background
+func (s *Subscriber) StopWithContext(ctx context.Context) error {
 	if err := s.stopped.setTrue(); err != nil {
 		return errors.New("SQS subscriber is already stopped")
 	}
-	return <-s.stop
+	close(s.stopping)
+
+	select {
+	case err := <-s.stop:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
 }
 
 func defaultSubscriberConfig(cfg *Config) {
-	if cfg.AWSSession == nil {
+	if cfg.AWSSession == nil && cfg.SQSClient == nil {
 		cfg.AWSSession = session.Must(session.NewSession())
 	}
 
+	if cfg.MaxVisibilityExtension == 0 {
+		cfg.MaxVisibilityExtension = defaultMaxVisibilityExtension
+	}
+
 	if cfg.NumConsumers == 0 {
 		cfg.NumConsumers = defaultNumConsumers
 	}
 
 	if cfg.Logger == nil {
-		cfg.Logger = log.New(os.Stdout, "", log.LstdFlags|log.LUTC)
+		cfg.Logger = NewPrintfLogger(log.New(os.Stdout, "", log.LstdFlags|log.LUTC), LevelInfo)
+	}
+
+	if cfg.Metrics == nil {
+		cfg.Metrics = metrics.Nop
 	}
 }
 
 // New creates a new AWS SQS subscriber
 func New(cfg Config) *Subscriber {
 	defaultSubscriberConfig(&cfg)
-	return &Subscriber{cfg: cfg, sqs: sqs.New(cfg.AWSSession), stop: make(chan error, 1)}
+	client := cfg.SQSClient
+	if client == nil {
+		client = sqs.New(cfg.AWSSession)
+	}
+	return &Subscriber{cfg: cfg, sqs: client, stop: make(chan error, 1), stopping: make(chan struct{}), logger: withFields(cfg.Logger, FieldQueueURL, cfg.SqsQueueURL)}
 }
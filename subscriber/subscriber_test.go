@@ -1,16 +1,21 @@
 package subscriber
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"os"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/aws/aws-sdk-go/service/sqs/sqsiface"
 	"github.com/stretchr/testify/require"
+
+	"github.com/bernardopericacho/htsqs/metrics"
 )
 
 func TestSubscriber(t *testing.T) {
@@ -25,7 +30,7 @@ func TestSubscriber(t *testing.T) {
 	go func() {
 		for i := 0; i < numMessages; i++ {
 			message := fmt.Sprintf("Message: %d", i)
-			queue <- &SQSMessage{subs, &sqs.Message{Body: &message}}
+			queue <- &SQSMessage{sub: subs, rawMessage: &sqs.Message{Body: &message}}
 		}
 		stopErrChannel <- subs.Stop()
 		close(stopErrChannel)
@@ -51,6 +56,23 @@ func TestSubscriber(t *testing.T) {
 	require.EqualError(t, err, "SQS subscriber is already stopped")
 }
 
+func TestSubscriberClient(t *testing.T) {
+	// AWS SDK clients can be used as they are
+	var _ SQSClient = sqsiface.SQSAPI(nil)
+
+	queue := make(chan *SQSMessage)
+	defer close(queue)
+	subs := New(Config{SQSClient: &sqsMock{queue: queue}})
+	require.Nil(t, subs.cfg.AWSSession)
+
+	messages, _, err := subs.Consume()
+	require.NoError(t, err)
+	message := "message"
+	queue <- &SQSMessage{sub: subs, rawMessage: &sqs.Message{Body: &message}}
+	require.Equal(t, "message", string((<-messages).Body()))
+	require.NoError(t, subs.Stop())
+}
+
 func TestSubscriberAlreadyRunning(t *testing.T) {
 	queue := make(chan *SQSMessage)
 	defer close(queue)
@@ -90,6 +112,74 @@ func TestSubscriberAlreadyRunning(t *testing.T) {
 	require.Nil(t, <-errsChannelStop)
 }
 
+func TestSubscriberStopLongPolling(t *testing.T) {
+	subs := New(Config{})
+	subs.sqs = &sqsMock{longPolling: true}
+
+	messages, errCh, err := subs.Consume()
+	require.NoError(t, err)
+
+	// In-flight receive requests are canceled and not reported as errors
+	stopped := make(chan error)
+	go func() {
+		stopped <- subs.Stop()
+	}()
+
+	select {
+	case err := <-stopped:
+		require.NoError(t, err)
+	case <-time.After(time.Second):
+		require.FailNow(t, "subscriber did not stop")
+	}
+	_, ok := <-messages
+	require.False(t, ok)
+	require.NoError(t, <-errCh)
+}
+
+func TestSubscriberStopWithContext(t *testing.T) {
+	queue := make(chan *SQSMessage)
+	defer close(queue)
+	subs := New(Config{NumConsumers: 1})
+	subs.sqs = &sqsMock{queue: queue}
+
+	messages, _, err := subs.Consume()
+	require.NoError(t, err)
+
+	// Nobody reads the messages, so the consumer is blocked pushing the second one
+	for i := 0; i < 2; i++ {
+		message := fmt.Sprintf("Message: %d", i)
+		queue <- &SQSMessage{sub: subs, rawMessage: &sqs.Message{Body: &message}}
+	}
+
+	// The blocked consumer drops the message and stops right away
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	start := time.Now()
+	require.NoError(t, subs.StopWithContext(ctx))
+	require.Less(t, int64(time.Since(start)), int64(100*time.Millisecond))
+
+	m, ok := <-messages
+	require.True(t, ok)
+	require.Equal(t, "Message: 0", string(m.Body()))
+	_, ok = <-messages
+	require.False(t, ok)
+}
+
+func TestSubscriberConsumeWithContext(t *testing.T) {
+	subs := New(Config{})
+	subs.sqs = &sqsMock{longPolling: true}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	messages, _, err := subs.ConsumeWithContext(ctx)
+	require.NoError(t, err)
+
+	// Consumers stop once the context is canceled
+	cancel()
+	_, ok := <-messages
+	require.False(t, ok)
+	require.NoError(t, subs.Stop())
+}
+
 func TestSubscriberDefaults(t *testing.T) {
 
 	tt := []struct {
@@ -99,13 +189,13 @@ func TestSubscriberDefaults(t *testing.T) {
 	}{
 		{
 			"Custom parameters",
-			Config{AWSSession: session.Must(session.NewSession()), MaxMessagesPerBatch: aws.Int64(1), TimeoutSeconds: aws.Int64(1), VisibilityTimeout: aws.Int64(1), NumConsumers: 1, Logger: log.New(os.Stderr, "", log.LstdFlags)},
-			Config{MaxMessagesPerBatch: aws.Int64(1), TimeoutSeconds: aws.Int64(1), VisibilityTimeout: aws.Int64(1), NumConsumers: 1, Logger: log.New(os.Stderr, "", log.LstdFlags)},
+			Config{AWSSession: session.Must(session.NewSession()), MaxMessagesPerBatch: aws.Int64(1), TimeoutSeconds: aws.Int64(1), VisibilityTimeout: aws.Int64(1), HeartbeatInterval: time.Minute, MaxVisibilityExtension: time.Hour, NumConsumers: 1, Logger: NewPrintfLogger(log.New(os.Stderr, "", log.LstdFlags), LevelDebug), Metrics: &metricsMock{}},
+			Config{MaxMessagesPerBatch: aws.Int64(1), TimeoutSeconds: aws.Int64(1), VisibilityTimeout: aws.Int64(1), HeartbeatInterval: time.Minute, MaxVisibilityExtension: time.Hour, NumConsumers: 1, Logger: NewPrintfLogger(log.New(os.Stderr, "", log.LstdFlags), LevelDebug), Metrics: &metricsMock{}},
 		},
 		{
 			"Use defaults parameters",
 			Config{},
-			Config{MaxMessagesPerBatch: nil, TimeoutSeconds: nil, VisibilityTimeout: nil, NumConsumers: 3, Logger: log.New(os.Stdout, "", log.LstdFlags|log.LUTC)},
+			Config{MaxMessagesPerBatch: nil, TimeoutSeconds: nil, VisibilityTimeout: nil, MaxVisibilityExtension: 12 * time.Hour, NumConsumers: 3, Logger: NewPrintfLogger(log.New(os.Stdout, "", log.LstdFlags|log.LUTC), LevelInfo), Metrics: metrics.Nop},
 		},
 	}
 
@@ -126,8 +216,20 @@ func TestSubscriberDefaults(t *testing.T) {
 				require.Equal(t, initialAWSSession, tc.sqsConfig.AWSSession)
 				tc.expectedAfterDefaults.AWSSession = initialAWSSession
 			}
+			// log.Logger keeps its prefix behind an atomic pointer, so two loggers created
+			// with the same arguments are never deeply equal. Compare their settings instead
+			requireSameLogger(t, tc.expectedAfterDefaults.Logger, tc.sqsConfig.Logger)
+			tc.sqsConfig.Logger, tc.expectedAfterDefaults.Logger = nil, nil
 			require.Exactly(t, tc.sqsConfig, tc.expectedAfterDefaults)
 
 		})
 	}
 }
+
+func requireSameLogger(t *testing.T, expected, actual Logger) {
+	require.Equal(t, expected.(*printfLogger).level, actual.(*printfLogger).level)
+	expectedLogger, actualLogger := expected.(*printfLogger).logger.(*log.Logger), actual.(*printfLogger).logger.(*log.Logger)
+	require.Equal(t, expectedLogger.Writer(), actualLogger.Writer())
+	require.Equal(t, expectedLogger.Prefix(), actualLogger.Prefix())
+	require.Equal(t, expectedLogger.Flags(), actualLogger.Flags())
+}
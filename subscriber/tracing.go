@@ -0,0 +1,69 @@
+package subscriber
+
+import (
+	"context"
+	"fmt"
+	"path"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	// tracerName is the name of the tracer creating the worker spans
+	tracerName = "github.com/bernardopericacho/htsqs/subscriber"
+)
+
+// attributesCarrier extracts the trace context from the message attributes
+type attributesCarrier map[string]*sqs.MessageAttributeValue
+
+func (c attributesCarrier) Get(key string) string {
+	if value, ok := c[key]; ok && value != nil {
+		return aws.StringValue(value.StringValue)
+	}
+	return ""
+}
+
+func (c attributesCarrier) Set(key, value string) {
+	c[key] = &sqs.MessageAttributeValue{DataType: aws.String("String"), StringValue: aws.String(value)}
+}
+
+func (c attributesCarrier) Keys() []string {
+	keys := make([]string, 0, len(c))
+	for key := range c {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// trace wraps the handler with a consumer span, child of the trace context propagated in the message attributes.
+// The handler context holds the span, and the span records the error returned by the handler
+func (w *Worker) trace(next Handler) Handler {
+	tracer := w.config.TracerProvider.Tracer(tracerName)
+	queueURL := w.config.Subscriber.cfg.SqsQueueURL
+	spanName := fmt.Sprintf("%s process", path.Base(queueURL))
+
+	return func(ctx context.Context, m *SQSMessage) error {
+		ctx = w.config.Propagator.Extract(ctx, attributesCarrier(m.MessageAttributes()))
+		ctx, span := tracer.Start(ctx, spanName,
+			trace.WithSpanKind(trace.SpanKindConsumer),
+			trace.WithAttributes(
+				attribute.String("messaging.system", "aws_sqs"),
+				attribute.String("messaging.destination", queueURL),
+				attribute.String("messaging.operation", "process"),
+				attribute.String("messaging.message_id", m.MessageID()),
+			),
+		)
+		defer span.End()
+
+		err := next(ctx, m)
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		return err
+	}
+}
@@ -0,0 +1,77 @@
+package subscriber
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestWorkerTracing(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+
+	queue := make(chan *SQSMessage)
+	defer close(queue)
+	subs := New(Config{SqsQueueURL: "https://sqs.us-east-1.amazonaws.com/123456789012/myQueue"})
+	subs.sqs = &sqsMock{queue: queue}
+
+	handlerSpans := make(chan trace.SpanContext, 2)
+	worker := NewWorker(WorkerConfig{
+		Subscriber: subs,
+		Handler: func(ctx context.Context, m *SQSMessage) error {
+			handlerSpans <- trace.SpanContextFromContext(ctx)
+			if string(m.Body()) == "fail" {
+				return errors.New("processing error")
+			}
+			return nil
+		},
+		TracerProvider: provider,
+		// Messages are processed one after the other, so spans are exported in the order the handlers run
+		MaxConcurrency: 1,
+	})
+	handled := dispatched(worker)
+
+	errsChannelStop := make(chan error)
+	go func() {
+		traced := "message"
+		queue <- &SQSMessage{sub: subs, rawMessage: &sqs.Message{Body: &traced, MessageAttributes: map[string]*sqs.MessageAttributeValue{
+			"traceparent": {DataType: aws.String("String"), StringValue: aws.String("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")},
+		}}}
+		failed := "fail"
+		queue <- &SQSMessage{sub: subs, rawMessage: &sqs.Message{Body: &failed}}
+		<-handled
+		<-handled
+		errsChannelStop <- worker.Stop()
+		close(errsChannelStop)
+	}()
+
+	require.Equal(t, ErrWorkerClosed, worker.Start(context.TODO()))
+	require.NoError(t, <-errsChannelStop)
+
+	spans := exporter.GetSpans()
+	require.Len(t, spans, 2)
+	for _, span := range spans {
+		require.Equal(t, "myQueue process", span.Name)
+		require.Equal(t, trace.SpanKindConsumer, span.SpanKind)
+		require.Equal(t, span.SpanContext, <-handlerSpans)
+
+		switch span.SpanContext.TraceID().String() {
+		case "4bf92f3577b34da6a3ce929d0e0e4736":
+			// The span continues the trace propagated in the message attributes
+			require.Equal(t, "00f067aa0ba902b7", span.Parent.SpanID().String())
+			require.True(t, span.Parent.IsRemote())
+			require.Equal(t, codes.Unset, span.Status.Code)
+		default:
+			require.False(t, span.Parent.IsValid())
+			require.Equal(t, codes.Error, span.Status.Code)
+		}
+	}
+}
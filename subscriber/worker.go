@@ -3,22 +3,58 @@ package subscriber
 import (
 	"context"
 	"errors"
-	"log"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/bernardopericacho/htsqs/metrics"
+	"github.com/bernardopericacho/htsqs/publisher"
 )
 
 // ErrWorkerClosed is returned by the Worker 'Start' method after a call to 'Stop'.
 var ErrWorkerClosed = errors.New("worker closed")
 
+// DrainError is returned by the Worker 'Shutdown' method when the context is done
+// before all the in-flight messages are processed
+type DrainError struct {
+	// Number of messages that were still being processed or waiting to be processed
+	Unfinished int
+
+	// Context error
+	Err error
+}
+
+func (e *DrainError) Error() string {
+	return fmt.Sprintf("worker shutdown left %d messages unfinished: %v", e.Unfinished, e.Err)
+}
+
+// Unwrap returns the context error
+func (e *DrainError) Unwrap() error {
+	return e.Err
+}
+
 func defaultMessageHandler(ctx context.Context, w *Worker, m *SQSMessage) {
-	log.Printf("Message received: '%s'", string(m.Body()))
+	w.logger().Log(LevelInfo, "Message received", messageFields(m, "body", string(m.Body()))...)
 	if err := m.Done(); err != nil {
-		log.Printf("Error when deleting message from SQS: %v", err)
+		w.logger().Log(LevelError, "Error when deleting message from SQS", messageFields(m, FieldError, err)...)
 	}
 }
 
 func defaultErrorHandler(ctx context.Context, w *Worker, e error) {
-	log.Printf("Error when receiving messages from SQS: %v", e)
-	w.lastErr <- e
+	// Panics are only logged, the worker keeps processing the messages
+	var panicErr *PanicError
+	if errors.As(e, &panicErr) {
+		w.logger().Log(LevelError, "Panic when processing message", FieldMessageID, panicErr.MessageID, FieldError, e)
+		return
+	}
+	w.logger().Log(LevelError, "Error when receiving messages from SQS", FieldError, e)
+	w.setLastErr(e)
 }
 
 // WorkerConfig is the worker startup config
@@ -30,8 +66,57 @@ type WorkerConfig struct {
 	// SQS Message Handler
 	MessageHandler func(context.Context, *Worker, *SQSMessage)
 
-	// SQS Error Handler
+	// SQS Message Handler reporting the processing outcome, the worker deletes or nacks the messages
+	// depending on the returned error. Takes precedence over MessageHandler
+	Handler Handler
+
+	// Middlewares wrapping the message processing, applied in order: the first middleware is the outermost one.
+	// They run within the consumer span of the message.
+	// They wrap the Handler or, when it is not set, the MessageHandler, whose messages are never acked
+	// by the worker and whose middleware errors are only logged
+	Middlewares []func(Handler) Handler
+
+	// Policy deciding when a message is delivered again after the Handler returns an error.
+	// When nil, the message is delivered again once its visibility timeout expires
+	RetryPolicy RetryPolicy
+
+	// Publisher the messages that can not be processed are sent to, along with their attributes and the
+	// failure details. Messages are dead-lettered and deleted when the Handler returns ErrDeadLetter or
+	// fails once the message has been received MaxReceiveCount times. Keep in mind AWS allows up to
+	// 10 message attributes and dead-lettering adds 4 of them. Required by the Routers dead-lettering the messages
+	// of unknown types, whose messages are nacked otherwise
+	DeadLetterPublisher publisher.Publisher
+
+	// Number of receives after which a message whose Handler fails is dead-lettered. Disabled when zero
+	MaxReceiveCount int
+
+	// SQS Error Handler. Also receives a *PanicError when a handler panics while processing a message.
+	// By default, errors are logged and the first one is returned by Start once the worker is stopped,
+	// except for the *PanicError, which are only logged
 	ErrorHandler func(context.Context, *Worker, error)
+
+	// Number of panics after which a message is quarantined: deleted or, when QuarantinePublisher is set,
+	// forwarded to QuarantinePublisher and deleted. Messages are quarantined once they panic more than MaxPanics
+	// times while being processed by this worker. Messages that panic are delivered again once their
+	// visibility timeout expires. The panics of a message are forgotten once it does not panic again within
+	// 12 hours, the maximum visibility timeout. Disabled when zero
+	MaxPanics int
+
+	// Publisher the quarantined messages are sent to, along with their attributes and the failure details
+	QuarantinePublisher publisher.Publisher
+
+	// Tracer provider creating a consumer span around the processing of each message. The span is a child of
+	// the trace context propagated in the message attributes and its context is passed to the handlers.
+	// The global tracer provider by default
+	TracerProvider trace.TracerProvider
+
+	// Propagator extracting the trace context from the message attributes. W3C trace context by default
+	Propagator propagation.TextMapPropagator
+
+	// Maximum number of messages processed concurrently. Once reached, the worker stops taking messages
+	// from the subscriber until a handler returns, which makes the subscriber consumers stop receiving
+	// messages from AWS SQS as soon as the subscriber messages channel is full. Unlimited when zero
+	MaxConcurrency int
 }
 
 func defaultWorkerConfig(cfg *WorkerConfig) {
@@ -41,12 +126,37 @@ func defaultWorkerConfig(cfg *WorkerConfig) {
 	if cfg.ErrorHandler == nil {
 		cfg.ErrorHandler = defaultErrorHandler
 	}
+	if cfg.TracerProvider == nil {
+		cfg.TracerProvider = otel.GetTracerProvider()
+	}
+	if cfg.Propagator == nil {
+		cfg.Propagator = propagation.TraceContext{}
+	}
 }
 
 // Worker represents a SQS worker service
 type Worker struct {
 	lastErr chan error
 	config  *WorkerConfig
+	groups  *groupDispatcher
+	// message handler wrapped by the middlewares
+	handler Handler
+	panics  *panicCounter
+	// one slot per message being processed, nil when the concurrency is unlimited
+	slots chan struct{}
+	// closed once Start has dispatched all the messages from the subscriber
+	dispatched chan struct{}
+	// in-flight messages, dispatched but not processed yet
+	handlers sync.WaitGroup
+	inFlight int32
+	// closed once Shutdown is called to stop dispatching the messages from the subscriber
+	closing   chan struct{}
+	closeOnce sync.Once
+	// messages received but not dispatched because the worker is shutting down
+	dropped int32
+	// subscriber messages channel, guarded by mu
+	mu       sync.Mutex
+	messages <-chan *SQSMessage
 }
 
 // Start triggers the process to start consuming messages from the SQS subscriber.
@@ -57,6 +167,9 @@ func (w *Worker) Start(ctx context.Context) error {
 	if err != nil {
 		return err
 	}
+	w.mu.Lock()
+	w.messages = sqsMessages
+	w.mu.Unlock()
 
 	// Process all errors in a goroutine
 	go func() {
@@ -65,22 +178,149 @@ func (w *Worker) Start(ctx context.Context) error {
 		}
 	}()
 
-	// Process each message in a goroutine
+	// Process each message in a goroutine. Messages from the same FIFO message group
+	// are processed one after the other to keep their order. Reports whether the message was handled,
+	// false when it is going to be delivered again
+	handle := func(m *SQSMessage) (handled bool) {
+		defer w.handlers.Done()
+		defer atomic.AddInt32(&w.inFlight, -1)
+		defer w.release()
+		defer m.stopHeartbeat()
+
+		// The outcome is recorded once a panic, if any, is recovered
+		start := time.Now()
+		outcome := metrics.OutcomePanicked
+		defer func() {
+			w.config.Subscriber.cfg.Metrics.Handle(w.config.Subscriber.cfg.SqsQueueURL, outcome, time.Since(start))
+		}()
+		defer w.recoverPanic(ctx, m)
+
+		if w.config.Handler != nil {
+			outcome = w.process(ctx, m)
+			return outcome != metrics.OutcomeNacked && outcome != metrics.OutcomeRetried
+		}
+		err := w.handler(ctx, m)
+		if err != nil {
+			w.logger().Log(LevelWarn, "Error when processing message", messageFields(m, FieldError, err)...)
+		}
+		outcome = metrics.OutcomeHandled
+		return err == nil
+	}
+
+	// Nack the messages of a FIFO message group queued after a message that was not handled,
+	// so they are delivered again after it
+	skip := func(m *SQSMessage) {
+		defer w.handlers.Done()
+		defer atomic.AddInt32(&w.inFlight, -1)
+		defer w.release()
+
+		m.stopHeartbeat()
+		if err := m.ChangeMessageVisibility(aws.Int64(0)); err != nil {
+			w.logger().Log(LevelWarn, "Error when changing message visibility", messageFields(m, FieldError, err)...)
+		}
+	}
 	for message := range sqsMessages {
-		go w.config.MessageHandler(ctx, w, message)
+		if !w.acquire() {
+			// The worker is shutting down, the message becomes visible again once its visibility timeout expires
+			message.stopHeartbeat()
+			atomic.AddInt32(&w.dropped, 1)
+			continue
+		}
+		w.handlers.Add(1)
+		atomic.AddInt32(&w.inFlight, 1)
+		if groupID := message.MessageGroupID(); groupID != "" {
+			w.groups.dispatch(groupID, message, handle, skip)
+			continue
+		}
+		go handle(message)
 	}
+	close(w.dispatched)
 
 	return <-w.lastErr
 }
 
+// acquire takes a processing slot, blocking until one is free.
+// Returns false, without taking a slot, once the worker is shutting down
+func (w *Worker) acquire() bool {
+	select {
+	case <-w.closing:
+		return false
+	default:
+	}
+	if w.slots == nil {
+		return true
+	}
+
+	select {
+	case w.slots <- struct{}{}:
+		return true
+	case <-w.closing:
+		return false
+	}
+}
+
+// release frees a processing slot
+func (w *Worker) release() {
+	if w.slots != nil {
+		<-w.slots
+	}
+}
+
 // Stop gracefully stops the subscriber.
+// Blocks until all the in-flight messages are processed
 func (w *Worker) Stop() error {
-	if err := w.config.Subscriber.Stop(); err != nil {
+	return w.Shutdown(context.Background())
+}
+
+// Shutdown gracefully stops the subscriber and waits for the in-flight messages to be processed
+// until the context is done. The received messages that are not being processed yet are not dispatched,
+// they become visible again in the queue once their visibility timeout expires. Returns a *DrainError
+// holding the number of unfinished messages, including the ones not dispatched, if the context is done first.
+// Unfinished handlers are not interrupted
+func (w *Worker) Shutdown(ctx context.Context) error {
+	w.closeOnce.Do(func() { close(w.closing) })
+	if err := w.config.Subscriber.StopWithContext(ctx); err != nil && err != ctx.Err() {
 		return err
 	}
-	w.lastErr <- ErrWorkerClosed
-	close(w.lastErr)
-	return nil
+
+	err := w.drain(ctx)
+	w.setLastErr(ErrWorkerClosed)
+	return err
+}
+
+// setLastErr sets the error returned by Start unless there is one already.
+// Never blocks, as errors keep being reported while the unfinished handlers run after Shutdown returns
+func (w *Worker) setLastErr(err error) {
+	select {
+	case w.lastErr <- err:
+	default:
+	}
+}
+
+// drain waits for all the in-flight messages to be processed until the context is done
+func (w *Worker) drain(ctx context.Context) error {
+	drained := make(chan struct{})
+	go func() {
+		<-w.dispatched
+		w.handlers.Wait()
+		close(drained)
+	}()
+
+	select {
+	case <-drained:
+		return nil
+	case <-ctx.Done():
+		unfinished := atomic.LoadInt32(&w.inFlight) + atomic.LoadInt32(&w.dropped)
+		w.mu.Lock()
+		unfinished += int32(len(w.messages))
+		w.mu.Unlock()
+		return &DrainError{Unfinished: int(unfinished), Err: ctx.Err()}
+	}
+}
+
+// logger returns the subscriber logger
+func (w *Worker) logger() Logger {
+	return w.config.Subscriber.logger
 }
 
 // Config returns current configuration
@@ -91,5 +331,21 @@ func (w *Worker) Config() *WorkerConfig {
 // NewWorker creates a new Worker based on the given configuration that process messages from AWS SQS
 func NewWorker(conf WorkerConfig) *Worker {
 	defaultWorkerConfig(&conf)
-	return &Worker{lastErr: make(chan error, 1), config: &conf}
+	w := &Worker{lastErr: make(chan error, 1), config: &conf, groups: newGroupDispatcher(), panics: newPanicCounter(maxVisibilityTimeout), dispatched: make(chan struct{}), closing: make(chan struct{})}
+	if conf.MaxConcurrency > 0 {
+		w.slots = make(chan struct{}, conf.MaxConcurrency)
+	}
+
+	w.handler = conf.Handler
+	if w.handler == nil {
+		w.handler = func(ctx context.Context, m *SQSMessage) error {
+			conf.MessageHandler(ctx, w, m)
+			return nil
+		}
+	}
+	for i := len(conf.Middlewares) - 1; i >= 0; i-- {
+		w.handler = conf.Middlewares[i](w.handler)
+	}
+	w.handler = w.trace(w.handler)
+	return w
 }
@@ -4,10 +4,15 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/aws/aws-sdk-go/service/sqs"
 	"github.com/stretchr/testify/require"
+
+	"github.com/bernardopericacho/htsqs/metrics"
 )
 
 func TestWorker(t *testing.T) {
@@ -44,6 +49,275 @@ func TestWorker(t *testing.T) {
 	require.EqualError(t, worker.Start(context.TODO()), "SQS subscriber is already stopped")
 }
 
+func TestWorkerFIFO(t *testing.T) {
+	numMessages := 10
+	c := new(WorkerConfig)
+
+	queue := make(chan *SQSMessage)
+	defer close(queue)
+	// AWS SQS does not deliver a message while others from the same group are in flight.
+	// The mock does not, so a single consumer keeps the messages in the order they are sent
+	subs := New(Config{NumConsumers: 1})
+	subs.sqs = &sqsMock{queue: queue}
+	c.Subscriber = subs
+
+	var mu sync.Mutex
+	handled := make(map[string][]string)
+	done := make(chan struct{}, 2*numMessages)
+	c.MessageHandler = func(ctx context.Context, w *Worker, m *SQSMessage) {
+		// Give later messages the chance to overtake the current one
+		time.Sleep(time.Millisecond)
+		mu.Lock()
+		handled[m.MessageGroupID()] = append(handled[m.MessageGroupID()], string(m.Body()))
+		mu.Unlock()
+		done <- struct{}{}
+	}
+	worker := NewWorker(*c)
+
+	errsChannelStop := make(chan error)
+	expected := make(map[string][]string)
+
+	go func() {
+		for i := 0; i < numMessages; i++ {
+			for _, group := range []string{"group 1", "group 2"} {
+				group := group
+				message := fmt.Sprintf("Message: %d", i)
+				expected[group] = append(expected[group], message)
+
+				queue <- &SQSMessage{
+					sub: subs,
+					rawMessage: &sqs.Message{
+						Body:       &message,
+						Attributes: map[string]*string{sqs.MessageSystemAttributeNameMessageGroupId: &group},
+					},
+				}
+			}
+		}
+		for i := 0; i < 2*numMessages; i++ {
+			<-done
+		}
+		errsChannelStop <- worker.Stop()
+		close(errsChannelStop)
+	}()
+
+	require.Equal(t, ErrWorkerClosed, worker.Start(context.TODO()))
+	require.NoError(t, <-errsChannelStop)
+	require.Equal(t, expected, handled)
+}
+
+func TestWorkerFIFOFailure(t *testing.T) {
+	queue := make(chan *SQSMessage)
+	defer close(queue)
+	mock := &sqsMock{queue: queue}
+	subs := New(Config{NumConsumers: 1})
+	subs.sqs = mock
+
+	var mu sync.Mutex
+	var handled []string
+	release := make(chan struct{})
+	worker := NewWorker(WorkerConfig{
+		Subscriber: subs,
+		Handler: func(ctx context.Context, m *SQSMessage) error {
+			// The first message of the group fails once the second one is queued behind it
+			<-release
+			mu.Lock()
+			defer mu.Unlock()
+			handled = append(handled, string(m.Body()))
+			return errors.New("processing error")
+		},
+	})
+
+	errsChannelStop := make(chan error)
+	go func() {
+		group := "group"
+		for _, message := range []string{"message 1", "message 2"} {
+			message := message
+			queue <- &SQSMessage{sub: subs, rawMessage: &sqs.Message{
+				Body:       &message,
+				Attributes: map[string]*string{sqs.MessageSystemAttributeNameMessageGroupId: &group},
+			}}
+		}
+		require.Eventually(t, func() bool { return atomic.LoadInt32(&worker.inFlight) == 2 }, time.Second, time.Millisecond)
+		close(release)
+		require.Eventually(t, func() bool { return atomic.LoadInt32(&worker.inFlight) == 0 }, time.Second, time.Millisecond)
+		errsChannelStop <- worker.Stop()
+		close(errsChannelStop)
+	}()
+
+	require.Equal(t, ErrWorkerClosed, worker.Start(context.TODO()))
+	require.NoError(t, <-errsChannelStop)
+
+	// The second message is not processed but nacked, so it is delivered again after the first one
+	require.Equal(t, []string{"message 1"}, handled)
+	require.Equal(t, int32(1), atomic.LoadInt32(&mock.visibilityChanges))
+	require.Equal(t, int64(0), atomic.LoadInt64(&mock.visibilityTimeout))
+	require.Equal(t, int32(0), atomic.LoadInt32(&mock.deletedMessages))
+}
+
+func TestWorkerMaxConcurrency(t *testing.T) {
+	numMessages := 20
+	maxConcurrency := 3
+	c := new(WorkerConfig)
+
+	queue := make(chan *SQSMessage)
+	defer close(queue)
+	subs := New(Config{})
+	subs.sqs = &sqsMock{queue: queue}
+	c.Subscriber = subs
+	c.MaxConcurrency = maxConcurrency
+
+	var running, maxRunning int32
+	done := make(chan struct{}, numMessages)
+	c.MessageHandler = func(ctx context.Context, w *Worker, m *SQSMessage) {
+		current := atomic.AddInt32(&running, 1)
+		for {
+			max := atomic.LoadInt32(&maxRunning)
+			if current <= max || atomic.CompareAndSwapInt32(&maxRunning, max, current) {
+				break
+			}
+		}
+		time.Sleep(20 * time.Millisecond)
+		atomic.AddInt32(&running, -1)
+		done <- struct{}{}
+	}
+	worker := NewWorker(*c)
+
+	errsChannelStop := make(chan error)
+	go func() {
+		for i := 0; i < numMessages; i++ {
+			message := fmt.Sprintf("Message: %d", i)
+			queue <- &SQSMessage{sub: subs, rawMessage: &sqs.Message{Body: &message}}
+		}
+		for i := 0; i < numMessages; i++ {
+			<-done
+		}
+		errsChannelStop <- worker.Stop()
+		close(errsChannelStop)
+	}()
+
+	require.Equal(t, ErrWorkerClosed, worker.Start(context.TODO()))
+	require.NoError(t, <-errsChannelStop)
+	require.LessOrEqual(t, atomic.LoadInt32(&maxRunning), int32(maxConcurrency))
+}
+
+func TestWorkerDrain(t *testing.T) {
+	c := new(WorkerConfig)
+
+	queue := make(chan *SQSMessage)
+	defer close(queue)
+	subs := New(Config{})
+	subs.sqs = &sqsMock{queue: queue}
+	c.Subscriber = subs
+
+	started := make(chan struct{})
+	var finished int32
+	c.MessageHandler = func(ctx context.Context, w *Worker, m *SQSMessage) {
+		close(started)
+		time.Sleep(20 * time.Millisecond)
+		atomic.StoreInt32(&finished, 1)
+	}
+	worker := NewWorker(*c)
+
+	errsChannelStop := make(chan error)
+	go func() {
+		message := fmt.Sprintf("Message")
+		queue <- &SQSMessage{sub: subs, rawMessage: &sqs.Message{Body: &message}}
+		<-started
+		errsChannelStop <- worker.Stop()
+		close(errsChannelStop)
+	}()
+
+	require.Equal(t, ErrWorkerClosed, worker.Start(context.TODO()))
+	require.NoError(t, <-errsChannelStop)
+	// Stop waits for the in-flight handler
+	require.Equal(t, int32(1), atomic.LoadInt32(&finished))
+}
+
+func TestWorkerShutdownTimeout(t *testing.T) {
+	c := new(WorkerConfig)
+
+	queue := make(chan *SQSMessage)
+	defer close(queue)
+	subs := New(Config{})
+	subs.sqs = &sqsMock{queue: queue}
+	c.Subscriber = subs
+
+	started := make(chan struct{}, 2)
+	release := make(chan struct{})
+	c.MessageHandler = func(ctx context.Context, w *Worker, m *SQSMessage) {
+		started <- struct{}{}
+		<-release
+	}
+	worker := NewWorker(*c)
+
+	errsChannelShutdown := make(chan error)
+	go func() {
+		for i := 0; i < 2; i++ {
+			message := fmt.Sprintf("Message: %d", i)
+			queue <- &SQSMessage{sub: subs, rawMessage: &sqs.Message{Body: &message}}
+			<-started
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		errsChannelShutdown <- worker.Shutdown(ctx)
+		close(errsChannelShutdown)
+	}()
+
+	require.Equal(t, ErrWorkerClosed, worker.Start(context.TODO()))
+	err := <-errsChannelShutdown
+	var drainErr *DrainError
+	require.True(t, errors.As(err, &drainErr))
+	require.Equal(t, 2, drainErr.Unfinished)
+	require.True(t, errors.Is(err, context.DeadlineExceeded))
+	close(release)
+}
+
+func TestWorkerShutdownTimeoutPending(t *testing.T) {
+	queue := make(chan *SQSMessage)
+	defer close(queue)
+	subs := New(Config{NumConsumers: 1, AckFlushInterval: time.Millisecond})
+	subs.sqs = &sqsMock{queue: queue}
+
+	release := make(chan struct{})
+	var handled int32
+	worker := NewWorker(WorkerConfig{
+		Subscriber: subs,
+		Handler: func(ctx context.Context, m *SQSMessage) error {
+			atomic.AddInt32(&handled, 1)
+			<-release
+			return nil
+		},
+		MaxConcurrency: 1,
+	})
+	started := dispatched(worker)
+	errCh := make(chan error, 1)
+	go func() { errCh <- worker.Start(context.TODO()) }()
+
+	// The first message blocks the only processing slot, the other ones wait to be dispatched
+	for i := 0; i < 3; i++ {
+		message := fmt.Sprintf("Message: %d", i)
+		queue <- &SQSMessage{sub: subs, rawMessage: &sqs.Message{Body: &message}}
+		if i == 0 {
+			<-started
+		}
+	}
+	time.Sleep(10 * time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	err := worker.Shutdown(ctx)
+	var drainErr *DrainError
+	require.True(t, errors.As(err, &drainErr))
+	require.Equal(t, 3, drainErr.Unfinished)
+	require.Equal(t, ErrWorkerClosed, <-errCh)
+
+	// Errors reported once Shutdown returns are handled, and the pending messages are never dispatched
+	require.NotPanics(t, func() { worker.config.ErrorHandler(context.TODO(), worker, errors.New("late error")) })
+	close(release)
+	time.Sleep(10 * time.Millisecond)
+	require.Equal(t, int32(1), atomic.LoadInt32(&handled))
+}
+
 func TestWorkerAlreadyRunning(t *testing.T) {
 	c := new(WorkerConfig)
 
@@ -103,3 +377,125 @@ func TestWorkerError(t *testing.T) {
 	require.EqualError(t, <-errsChannelStart, AWSError.Error())
 
 }
+
+func TestWorkerMiddlewares(t *testing.T) {
+	var mu sync.Mutex
+	var calls []string
+	record := func(name string) func(Handler) Handler {
+		return func(next Handler) Handler {
+			return func(ctx context.Context, m *SQSMessage) error {
+				mu.Lock()
+				calls = append(calls, name)
+				mu.Unlock()
+				return next(ctx, m)
+			}
+		}
+	}
+
+	tt := []struct {
+		name            string
+		config          WorkerConfig
+		expectedCalls   []string
+		expectedDeletes int32
+	}{
+		{
+			"Handler",
+			WorkerConfig{
+				Handler: func(ctx context.Context, m *SQSMessage) error {
+					mu.Lock()
+					calls = append(calls, "handler")
+					mu.Unlock()
+					return nil
+				},
+				Middlewares: []func(Handler) Handler{record("first"), record("second")},
+			},
+			[]string{"first", "second", "handler"},
+			1,
+		},
+		{
+			"Message handler",
+			WorkerConfig{
+				MessageHandler: func(ctx context.Context, w *Worker, m *SQSMessage) {
+					mu.Lock()
+					calls = append(calls, "message handler")
+					mu.Unlock()
+				},
+				Middlewares: []func(Handler) Handler{record("first")},
+			},
+			[]string{"first", "message handler"},
+			0,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			calls = nil
+			queue := make(chan *SQSMessage)
+			defer close(queue)
+			mock := &sqsMock{queue: queue}
+			subs := New(Config{})
+			subs.sqs = mock
+			tc.config.Subscriber = subs
+			worker := NewWorker(tc.config)
+			handled := dispatched(worker)
+
+			errsChannelStop := make(chan error)
+			go func() {
+				message := "message"
+				queue <- &SQSMessage{sub: subs, rawMessage: &sqs.Message{Body: &message}}
+				<-handled
+				errsChannelStop <- worker.Stop()
+				close(errsChannelStop)
+			}()
+
+			require.Equal(t, ErrWorkerClosed, worker.Start(context.TODO()))
+			require.NoError(t, <-errsChannelStop)
+			require.Equal(t, tc.expectedCalls, calls)
+			require.Equal(t, tc.expectedDeletes, atomic.LoadInt32(&mock.deletedMessages))
+		})
+	}
+}
+
+func TestWorkerMetrics(t *testing.T) {
+	queue := make(chan *SQSMessage)
+	defer close(queue)
+	metricsRecorder := &metricsMock{}
+	subs := New(Config{Metrics: metricsRecorder})
+	subs.sqs = &sqsMock{queue: queue}
+
+	worker := NewWorker(WorkerConfig{
+		Subscriber: subs,
+		Handler: func(ctx context.Context, m *SQSMessage) error {
+			switch string(m.Body()) {
+			case "retry":
+				return RetryAfter(time.Second, errors.New("processing error"))
+			case "panic":
+				panic("handler panic")
+			default:
+				return nil
+			}
+		},
+		ErrorHandler: func(context.Context, *Worker, error) {},
+	})
+	handled := dispatched(worker)
+
+	errsChannelStop := make(chan error)
+	go func() {
+		for _, body := range []string{"ack", "retry", "panic"} {
+			message := body
+			queue <- &SQSMessage{sub: subs, rawMessage: &sqs.Message{Body: &message}}
+			<-handled
+		}
+		errsChannelStop <- worker.Stop()
+		close(errsChannelStop)
+	}()
+
+	require.Equal(t, ErrWorkerClosed, worker.Start(context.TODO()))
+	require.NoError(t, <-errsChannelStop)
+
+	require.GreaterOrEqual(t, metricsRecorder.receives, 3)
+	require.Equal(t, 3, metricsRecorder.messagesReceived)
+	require.Equal(t, 1, metricsRecorder.acks)
+	require.Equal(t, 1, metricsRecorder.visibilityChanges)
+	require.ElementsMatch(t, []metrics.Outcome{metrics.OutcomeAcked, metrics.OutcomeRetried, metrics.OutcomePanicked}, metricsRecorder.outcomes)
+}